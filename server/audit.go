@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// auditSchemaVersion is the version of the audit records. It's incremented when a field is
+	// removed or changes meaning, never when a field is added.
+	auditSchemaVersion = 1
+
+	// Stages of the hooks the audit records come from.
+	auditStageFilter  = "filter"
+	auditStageRewrite = "rewrite"
+
+	// ruleFilter is the rule of the audit records about the outcome of the whole post.
+	ruleFilter = "filter"
+	// ruleCleanup and ruleSafeLinks are the rules of the audit records about the links rewritten
+	// by cleanupLinks and rewriteSafeLinks.
+	ruleCleanup   = "cleanup"
+	ruleSafeLinks = "safe_links"
+	// auditActionAllow is the action of the audit records of allowed posts.
+	auditActionAllow = "allow"
+
+	// Default rotation of the audit file.
+	defaultAuditFileMaxSize    = 100
+	defaultAuditFileMaxBackups = 10
+)
+
+// auditRecord is a decision of the link filter, written to the plugin logs and the audit file.
+// All the fields are always present, so the records can be ingested with a fixed schema.
+type auditRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	Time          string `json:"time"`
+	Stage         string `json:"stage"`
+	Rule          string `json:"rule"`
+	Action        string `json:"action"`
+	PostID        string `json:"post_id"`
+	UserID        string `json:"user_id"`
+	ChannelID     string `json:"channel_id"`
+	IsEdit        bool   `json:"is_edit"`
+	Scheme        string `json:"scheme"`
+	Host          string `json:"host"`
+	// URL and Reason are empty when the privacy mode applies to the post.
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+	// Links are the scheme and host of the links of the post, for the records about the whole post.
+	Links []string `json:"links"`
+}
+
+// keyValuePairs returns the fields of the record for the plugin logger, in the order of the schema.
+func (r *auditRecord) keyValuePairs() []interface{} {
+	return []interface{}{
+		"schema_version", r.SchemaVersion,
+		"time", r.Time,
+		"stage", r.Stage,
+		"rule", r.Rule,
+		"action", r.Action,
+		"post_id", r.PostID,
+		"user_id", r.UserID,
+		"channel_id", r.ChannelID,
+		"is_edit", r.IsEdit,
+		"scheme", r.Scheme,
+		"host", r.Host,
+		"url", r.URL,
+		"reason", r.Reason,
+		"links", strings.Join(r.Links, " "),
+	}
+}
+
+// auditDecision records a decision about a link of the post, or about the whole post if u is nil,
+// when the audit log is enabled.
+func (p *Plugin) auditDecision(post *model.Post, isEdit bool, u *detectedURL, rule, action, reason string) {
+	if !p.getConfiguration().EnableAuditLog {
+		return
+	}
+
+	stage := auditStageFilter
+	if rule == ruleRewriteProtocols || rule == ruleCleanup || rule == ruleSafeLinks {
+		stage = auditStageRewrite
+	}
+	record := &auditRecord{
+		SchemaVersion: auditSchemaVersion,
+		Time:          time.Now().UTC().Format(time.RFC3339Nano),
+		Stage:         stage,
+		Rule:          rule,
+		Action:        action,
+		PostID:        post.Id,
+		UserID:        post.UserId,
+		ChannelID:     post.ChannelId,
+		IsEdit:        isEdit,
+		Reason:        reason,
+		Links:         []string{},
+	}
+	if u != nil {
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		record.Scheme = u.canonical.scheme
+		record.Host = u.canonical.host
+		record.URL = u.canonical.String()
+	}
+	if p.isPrivacyModeApplied(post) {
+		record.URL = ""
+		record.Reason = ""
+	}
+
+	p.writeAuditRecord(record)
+}
+
+// auditCleanedLinks records the links of the allowed post cleaned up by cleanupLinks. It's called
+// once the post has passed all the stages of the filter, as cleanupLinks also runs in dry runs.
+func (p *Plugin) auditCleanedLinks(detectedURLs []*detectedURL, post *model.Post, isEdit bool) {
+	for _, u := range detectedURLs {
+		if u.cleaned {
+			p.auditDecision(post, isEdit, u, ruleCleanup, actionRewrite, "Tracking parameters or redirect wrappers removed")
+		}
+	}
+}
+
+// auditAllowedPost records that the post and its links were allowed, once the post has passed all
+// the stages of the filter. Posts without links aren't recorded.
+func (p *Plugin) auditAllowedPost(detectedURLs []*detectedURL, post *model.Post, isEdit bool) {
+	if len(detectedURLs) == 0 || !p.getConfiguration().EnableAuditLog {
+		return
+	}
+
+	record := &auditRecord{
+		SchemaVersion: auditSchemaVersion,
+		Time:          time.Now().UTC().Format(time.RFC3339Nano),
+		Stage:         auditStageFilter,
+		Rule:          ruleFilter,
+		Action:        auditActionAllow,
+		PostID:        post.Id,
+		UserID:        post.UserId,
+		ChannelID:     post.ChannelId,
+		IsEdit:        isEdit,
+		Links:         linkMetadata(detectedURLs),
+	}
+
+	p.writeAuditRecord(record)
+}
+
+// writeAuditRecord writes the record to the plugin logs and, if configured, to the audit file as
+// a JSON line.
+func (p *Plugin) writeAuditRecord(record *auditRecord) {
+	p.API.LogInfo("Link filter audit record", record.keyValuePairs()...)
+
+	p.auditFileLock.Lock()
+	defer p.auditFileLock.Unlock()
+	if p.auditFile == nil {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		p.API.LogError("Failed to marshal an audit record", "error", err.Error())
+		return
+	}
+	if _, err := p.auditFile.Write(append(data, '\n')); err != nil {
+		p.API.LogError("Failed to write an audit record", "error", err.Error())
+	}
+}
+
+// configureAuditFile opens the audit file of the configuration, rotated according to its size
+// and backup limits, and closes the previous one. The file is only opened on the first write.
+func (p *Plugin) configureAuditFile(configuration *configuration) error {
+	var auditFile *lumberjack.Logger
+	if configuration.EnableAuditLog && strings.TrimSpace(configuration.AuditLogFile) != "" {
+		maxSize := configuration.AuditLogMaxSize
+		if maxSize <= 0 {
+			maxSize = defaultAuditFileMaxSize
+		}
+		maxBackups := configuration.AuditLogMaxBackups
+		if maxBackups <= 0 {
+			maxBackups = defaultAuditFileMaxBackups
+		}
+		auditFile = &lumberjack.Logger{
+			Filename:   strings.TrimSpace(configuration.AuditLogFile),
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+		}
+	}
+
+	p.auditFileLock.Lock()
+	defer p.auditFileLock.Unlock()
+
+	if p.auditFile != nil && auditFile != nil &&
+		p.auditFile.Filename == auditFile.Filename &&
+		p.auditFile.MaxSize == auditFile.MaxSize &&
+		p.auditFile.MaxBackups == auditFile.MaxBackups {
+		return nil
+	}
+	err := p.closeAuditFile()
+	p.auditFile = auditFile
+
+	return err
+}
+
+// stopAuditFile closes the audit file when the plugin is deactivated.
+func (p *Plugin) stopAuditFile() error {
+	p.auditFileLock.Lock()
+	defer p.auditFileLock.Unlock()
+
+	return p.closeAuditFile()
+}
+
+// closeAuditFile closes the audit file. The caller must hold auditFileLock.
+func (p *Plugin) closeAuditFile() error {
+	if p.auditFile == nil {
+		return nil
+	}
+
+	err := p.auditFile.Close()
+	p.auditFile = nil
+	return errors.Wrap(err, "failed to close the audit file")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// readAuditFile returns the records of the audit file.
+func readAuditFile(t *testing.T, path string) []*auditRecord {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []*auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record auditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, &record)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+// findAuditRecord returns the last record of the audit file about the post with the rule.
+func findAuditRecord(t *testing.T, p *Plugin, postID, rule string) *auditRecord {
+	var found *auditRecord
+	for _, record := range readAuditFile(t, p.getConfiguration().AuditLogFile) {
+		if record.PostID == postID && record.Rule == rule {
+			found = record
+		}
+	}
+	require.NotNil(t, found, "no %s record for %s", rule, postID)
+
+	return found
+}
+
+func TestAuditLog(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "tel")
+	p.configuration.EnableAuditLog = true
+	p.configuration.AuditLogFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	p.configuration.DirectMessagePrivacyMode = true
+	api := &mockAPI{channels: map[string]*model.Channel{
+		"channel1": {Id: "channel1", TeamId: "team1", Type: model.CHANNEL_OPEN},
+		"dm1":      {Id: "dm1", Type: model.CHANNEL_DIRECT},
+	}}
+	p.API = api
+	require.NoError(t, p.configureAuditFile(p.configuration))
+	defer func() { require.NoError(t, p.stopAuditFile()) }()
+
+	var tests = []struct {
+		name           string
+		post           *model.Post
+		expectedRecord auditRecord
+	}{
+		{
+			name: "rejected scheme",
+			post: &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "[file](ftp://files.example.com/report.pdf)"},
+			expectedRecord: auditRecord{
+				Stage: auditStageFilter, Rule: ruleProtocols, Action: actionReject,
+				PostID: "post1", UserID: "user1", ChannelID: "channel1",
+				Scheme: "ftp", Host: "files.example.com", URL: "ftp://files.example.com/report.pdf",
+				Reason: "Scheme not allowed: ftp", Links: []string{},
+			},
+		},
+		{
+			name: "rejected scheme in private channels",
+			post: &model.Post{Id: "post2", UserId: "user1", ChannelId: "dm1", Message: "[file](ftp://files.example.com/report.pdf)"},
+			expectedRecord: auditRecord{
+				Stage: auditStageFilter, Rule: ruleProtocols, Action: actionReject,
+				PostID: "post2", UserID: "user1", ChannelID: "dm1",
+				Scheme: "ftp", Host: "files.example.com", Links: []string{},
+			},
+		},
+		{
+			name: "allowed",
+			post: &model.Post{Id: "post3", UserId: "user1", ChannelId: "channel1", Message: "[site](https://example.com/page)"},
+			expectedRecord: auditRecord{
+				Stage: auditStageFilter, Rule: ruleFilter, Action: auditActionAllow,
+				PostID: "post3", UserID: "user1", ChannelID: "channel1",
+				Links: []string{"https://example.com"},
+			},
+		},
+		{
+			name: "rewritten scheme",
+			post: &model.Post{Id: "post4", UserId: "user1", ChannelId: "channel1", Message: "call tel:123456"},
+			expectedRecord: auditRecord{
+				Stage: auditStageRewrite, Rule: ruleRewriteProtocols, Action: actionRewrite,
+				PostID: "post4", UserID: "user1", ChannelID: "channel1",
+				Scheme: "tel", URL: "tel:123456", Reason: "Scheme rewritten: tel", Links: []string{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api.infoLogs = nil
+			_, _ = p.MessageWillBePosted(nil, test.post)
+
+			record := findAuditRecord(t, p, test.post.Id, test.expectedRecord.Rule)
+			assert.Equal(t, auditSchemaVersion, record.SchemaVersion)
+			assert.NotEmpty(t, record.Time)
+			record.SchemaVersion = 0
+			record.Time = ""
+			assert.Equal(t, test.expectedRecord, *record)
+
+			var logged *mockLog
+			for i := range api.infoLogs {
+				if api.infoLogs[i].message == "Link filter audit record" && api.infoLogs[i].keyValuePairs[7] == test.expectedRecord.Rule {
+					logged = &api.infoLogs[i]
+				}
+			}
+			require.NotNil(t, logged)
+			assert.Equal(t, "schema_version", logged.keyValuePairs[0])
+			assert.Equal(t, test.expectedRecord.Rule, logged.keyValuePairs[7])
+		})
+	}
+}
+
+func TestAuditLogStages(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.EnableAuditLog = true
+	p.configuration.AuditLogFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	p.configuration.CleanupLinks = true
+	p.configuration.StripQueryParameters = "utm_*"
+	p.configuration.SafeLinkMode = safeLinkModeTemplate
+	p.configuration.SafeLinkTemplate = "https://safelinks.example.com/?url={{urlencoded}}"
+	p.configuration.RateLimitLinks = 1
+	p.configuration.RateLimitWindowSeconds = 60
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+	require.NoError(t, p.configureAuditFile(p.configuration))
+	defer func() { require.NoError(t, p.stopAuditFile()) }()
+
+	_, errMessage := p.MessageWillBePosted(nil, &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "[site](https://example.com/page?utm_source=x)"})
+	require.Empty(t, errMessage)
+
+	cleanup := findAuditRecord(t, p, "post1", ruleCleanup)
+	assert.Equal(t, auditStageRewrite, cleanup.Stage)
+	assert.Equal(t, actionRewrite, cleanup.Action)
+	assert.Equal(t, "https://example.com/page", cleanup.URL)
+	safeLink := findAuditRecord(t, p, "post1", ruleSafeLinks)
+	assert.Equal(t, auditStageRewrite, safeLink.Stage)
+	assert.Equal(t, "https://example.com/page", safeLink.URL)
+	findAuditRecord(t, p, "post1", ruleFilter)
+
+	// Posts rejected after FilterPost aren't recorded as allowed
+	_, errMessage = p.MessageWillBePosted(nil, &model.Post{Id: "post2", UserId: "user1", ChannelId: "channel1", Message: "[site](https://example.com/other)"})
+	require.NotEmpty(t, errMessage)
+	for _, record := range readAuditFile(t, p.configuration.AuditLogFile) {
+		if record.PostID == "post2" {
+			assert.Equal(t, ruleRateLimit, record.Rule)
+		}
+	}
+	findAuditRecord(t, p, "post2", ruleRateLimit)
+
+	// Dry runs aren't recorded
+	records := len(readAuditFile(t, p.configuration.AuditLogFile))
+	p.evaluatePost(&model.Post{Id: "post3", UserId: "user2", Message: "[site](https://example.com/page?utm_source=x)"}, time.Now())
+	assert.Len(t, readAuditFile(t, p.configuration.AuditLogFile), records)
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	api := &mockAPI{}
+	p.API = api
+
+	post := &model.Post{UserId: "user1", Message: "[file](ftp://files.example.com)"}
+	_ = p.FilterPost(p.extractURLs(post), post, false)
+	for _, log := range api.infoLogs {
+		assert.NotEqual(t, "Link filter audit record", log.message)
+	}
+}
+
+func TestConfigureAuditFile(t *testing.T) {
+	p := &Plugin{}
+	configuration := &configuration{EnableAuditLog: true, AuditLogFile: filepath.Join(t.TempDir(), "audit.jsonl")}
+
+	require.NoError(t, p.configureAuditFile(configuration))
+	require.NotNil(t, p.auditFile)
+	assert.Equal(t, defaultAuditFileMaxSize, p.auditFile.MaxSize)
+	assert.Equal(t, defaultAuditFileMaxBackups, p.auditFile.MaxBackups)
+
+	auditFile := p.auditFile
+	require.NoError(t, p.configureAuditFile(configuration))
+	assert.Same(t, auditFile, p.auditFile, "the audit file shouldn't be reopened if its configuration didn't change")
+
+	configuration.AuditLogMaxSize = 5
+	require.NoError(t, p.configureAuditFile(configuration))
+	assert.NotSame(t, auditFile, p.auditFile)
+	assert.Equal(t, 5, p.auditFile.MaxSize)
+
+	configuration.EnableAuditLog = false
+	require.NoError(t, p.configureAuditFile(configuration))
+	assert.Nil(t, p.auditFile)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// adminsPerPage is the number of system admins fetched at once when alerting them.
+const adminsPerPage = 100
+
+// ensureBot creates the bot the plugin posts alerts with, if it doesn't exist yet.
+func (p *Plugin) ensureBot() error {
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    "link-filter",
+		DisplayName: "Link Filter",
+		Description: "Alerts system admins about the link filter.",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure the bot")
+	}
+	p.botUserID = botUserID
+
+	return nil
+}
+
+// alertAdmins sends the message to every active system admin, in a direct message from the bot.
+func (p *Plugin) alertAdmins(message string) {
+	if p.botUserID == "" {
+		return
+	}
+
+	for page := 0; ; page++ {
+		admins, appErr := p.API.GetUsers(&model.UserGetOptions{
+			Role:    model.SYSTEM_ADMIN_ROLE_ID,
+			Active:  true,
+			Page:    page,
+			PerPage: adminsPerPage,
+		})
+		if appErr != nil {
+			p.API.LogError("Failed to get the system admins to alert", "error", appErr.Error())
+			return
+		}
+
+		for _, admin := range admins {
+			channel, appErr := p.API.GetDirectChannel(p.botUserID, admin.Id)
+			if appErr != nil {
+				p.API.LogError("Failed to get the direct channel to alert a system admin", "user_id", admin.Id, "error", appErr.Error())
+				continue
+			}
+			if _, appErr = p.API.CreatePost(&model.Post{
+				UserId:    p.botUserID,
+				ChannelId: channel.Id,
+				Message:   message,
+			}); appErr != nil {
+				p.API.LogError("Failed to alert a system admin", "user_id", admin.Id, "error", appErr.Error())
+			}
+		}
+
+		if len(admins) < adminsPerPage {
+			return
+		}
+	}
+}
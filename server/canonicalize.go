@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// canonicalURL is the normalised form of a detectedURL. Host policies are evaluated against this
+// form rather than the raw text of the post, so that differences in capitalization, encoding or
+// notation can't be used to sneak a link past the filter.
+type canonicalURL struct {
+	scheme string
+	// userinfo is the credentials part of the authority (e.g. "good.com" in
+	// https://good.com@evil.com). It's stripped from the host and kept only for reporting.
+	userinfo string
+	// host is the lowercased ASCII (punycode) form of the host, without trailing dots.
+	// Numeric IPv4 notations are resolved to the dotted decimal form.
+	host string
+	// unicodeHost is the host converted back to Unicode, used to display IDN hosts.
+	unicodeHost string
+	port        string
+	path        string
+	rawQuery    string
+	// opaque holds the scheme specific part of URLs without an authority (e.g. tel:1234).
+	opaque string
+	isIP   bool
+}
+
+// String returns the canonical URL in text form.
+func (c *canonicalURL) String() string {
+	if c.opaque != "" || c.host == "" {
+		return c.scheme + ":" + c.opaque
+	}
+
+	var builder strings.Builder
+	builder.WriteString(c.scheme)
+	builder.WriteString("://")
+	if strings.Contains(c.host, ":") {
+		builder.WriteString("[" + c.host + "]")
+	} else {
+		builder.WriteString(c.host)
+	}
+	if c.port != "" {
+		builder.WriteString(":" + c.port)
+	}
+	builder.WriteString(c.path)
+	if c.rawQuery != "" {
+		builder.WriteString("?" + c.rawQuery)
+	}
+
+	return builder.String()
+}
+
+// canonicalizeURLs computes the canonical form of each detected URL. It must be called after
+// extractURLs and before any policy is evaluated.
+func (p *Plugin) canonicalizeURLs(detectedURLs []*detectedURL) {
+	for _, u := range detectedURLs {
+		u.canonical = canonicalize(u.protocol, u.rawURL)
+	}
+}
+
+// canonicalURLs returns the text form of the canonical URLs, for use in reports.
+func canonicalURLs(detectedURLs []*detectedURL) []string {
+	var urls []string
+	for _, u := range detectedURLs {
+		if u.canonical != nil {
+			urls = append(urls, u.canonical.String())
+		}
+	}
+	return urls
+}
+
+// specialSchemes are the schemes whose URLs browsers always parse with an authority, whatever the
+// number of slashes or backslashes after the scheme. e.g. https:evil.com and https:\\evil.com both
+// open https://evil.com
+var specialSchemes = []string{"http", "https", "ftp", "ws", "wss"}
+
+// canonicalize normalises a raw URL. It never fails: parts that can't be decoded are kept in
+// their lowercased raw form so policies still get something to match against.
+func canonicalize(protocol, rawURL string) *canonicalURL {
+	c := &canonicalURL{
+		scheme: strings.ToLower(protocol),
+	}
+
+	rest := strings.TrimPrefix(rawURL, protocol)
+	rest = strings.TrimPrefix(rest, ":")
+	special := slices.Contains(specialSchemes, c.scheme)
+	if !special && !strings.HasPrefix(rest, "//") {
+		// URLs without an authority, e.g. mailto:user@example.com or tel:1234
+		c.opaque = rest
+		if c.scheme == "mailto" {
+			if at := strings.LastIndex(rest, "@"); at >= 0 {
+				c.userinfo = rest[:at]
+				c.host, c.unicodeHost, c.isIP = canonicalizeHost(strings.SplitN(rest[at+1:], "?", 2)[0])
+				c.opaque = c.userinfo + "@" + c.host
+			}
+		}
+		return c
+	}
+	if special {
+		rest = strings.TrimLeft(rest, `/\`)
+	} else {
+		rest = strings.TrimPrefix(rest, "//")
+	}
+
+	authority := rest
+	if i := strings.IndexAny(rest, `/\?#`); i >= 0 {
+		authority = rest[:i]
+		rest = rest[i:]
+	} else {
+		rest = ""
+	}
+
+	// Everything up to the last @ is userinfo, which is a common way to make a link look
+	// like it points to a trusted host.
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		c.userinfo = authority[:at]
+		authority = authority[at+1:]
+	}
+
+	host, port := splitHostPort(authority)
+	c.port = port
+	c.host, c.unicodeHost, c.isIP = canonicalizeHost(host)
+
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest = rest[:i]
+	}
+	path := rest
+	if i := strings.Index(rest, "?"); i >= 0 {
+		path = rest[:i]
+		c.rawQuery = rest[i+1:]
+	}
+	if special {
+		path = strings.ReplaceAll(path, `\`, "/")
+	}
+	if decoded, err := url.PathUnescape(path); err == nil {
+		path = decoded
+	}
+	// Dot segments are removed after decoding, as %2F..%2F is resolved the same way by servers
+	c.path = removeDotSegments(path)
+
+	return c
+}
+
+// removeDotSegments resolves the "." and ".." segments of an absolute path, following
+// RFC 3986 section 5.2.4. Unlike path.Clean it keeps the trailing slash and the empty segments.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")
+	output := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			// The first segment is the empty one before the leading slash
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+		default:
+			output = append(output, segment)
+			continue
+		}
+		if last {
+			output = append(output, "")
+		}
+	}
+
+	return strings.Join(output, "/")
+}
+
+// splitHostPort splits the authority into host and port. Unlike net.SplitHostPort it accepts
+// authorities without a port and returns IPv6 literals without brackets.
+func splitHostPort(authority string) (host, port string) {
+	if strings.HasPrefix(authority, "[") {
+		end := strings.Index(authority, "]")
+		if end < 0 {
+			return authority, ""
+		}
+		host = authority[1:end]
+		if strings.HasPrefix(authority[end+1:], ":") {
+			port = authority[end+2:]
+		}
+		return host, port
+	}
+
+	if i := strings.LastIndex(authority, ":"); i >= 0 {
+		if _, err := strconv.Atoi(authority[i+1:]); err == nil {
+			return authority[:i], authority[i+1:]
+		}
+	}
+
+	return authority, ""
+}
+
+// canonicalizeHost decodes, lowercases and IDNA-converts a host. It returns the ASCII form, the
+// Unicode form and whether the host is an IP literal.
+func canonicalizeHost(host string) (asciiHost, unicodeHost string, isIP bool) {
+	if decoded, err := url.PathUnescape(host); err == nil {
+		host = decoded
+	}
+	host = strings.TrimRight(strings.ToLower(host), ".")
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), ip.String(), true
+	}
+	if ip := parseNumericIPv4(host); ip != nil {
+		return ip.String(), ip.String(), true
+	}
+
+	asciiHost, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		asciiHost = host
+	}
+	asciiHost = strings.TrimRight(asciiHost, ".")
+
+	unicodeHost, err = idna.Display.ToUnicode(asciiHost)
+	if err != nil {
+		unicodeHost = host
+	}
+
+	return asciiHost, unicodeHost, false
+}
+
+// parseNumericIPv4 parses the IPv4 notations accepted by inet_aton, where the address may be
+// given as one to four parts, each in decimal, octal (leading 0) or hexadecimal (leading 0x).
+// e.g. 3232235777, 0xC0A80001, 0300.0250.1 and 192.168.1 all resolve to 192.168.0.1 or similar.
+func parseNumericIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) == 0 || len(parts) > 4 {
+		return nil
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		if part == "" {
+			return nil
+		}
+		v, err := parseIPv4Part(part)
+		if err != nil {
+			return nil
+		}
+		values[i] = v
+	}
+
+	// All parts but the last are single bytes, the last part fills the remaining bytes.
+	var ip uint64
+	for _, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return nil
+		}
+		ip = ip<<8 | v
+	}
+	last := values[len(values)-1]
+	remainingBits := uint(8 * (5 - len(values)))
+	if last >= 1<<remainingBits {
+		return nil
+	}
+	ip = ip<<remainingBits | last
+
+	return net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+}
+
+func parseIPv4Part(part string) (uint64, error) {
+	lower := strings.ToLower(part)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		return strconv.ParseUint(lower[2:], 16, 32)
+	case len(lower) > 1 && strings.HasPrefix(lower, "0"):
+		return strconv.ParseUint(lower[1:], 8, 32)
+	default:
+		return strconv.ParseUint(lower, 10, 32)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCanonicalizeURLs(t *testing.T) {
+	p := newTestPlugin(t, true, "", "", "")
+
+	var tests = []struct {
+		name              string
+		message           string
+		expectedCanonical string
+		expectedHost      string
+		expectedUserinfo  string
+		expectedIsIP      bool
+	}{
+		{
+			name:              "lowercases scheme and host",
+			message:           "HtTpS://WWW.GitHub.COM/Path",
+			expectedCanonical: "https://www.github.com/Path",
+			expectedHost:      "www.github.com",
+		},
+		{
+			name:              "strips userinfo",
+			message:           "[test](https://good.com@evil.com/login)",
+			expectedCanonical: "https://evil.com/login",
+			expectedHost:      "evil.com",
+			expectedUserinfo:  "good.com",
+		},
+		{
+			name:              "decodes percent-encoded host",
+			message:           "https://%65%76%69%6c.com",
+			expectedCanonical: "https://evil.com",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "removes trailing dots",
+			message:           "https://evil.com../",
+			expectedCanonical: "https://evil.com/",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "converts IDN hosts to punycode",
+			message:           "https://bücher.example/",
+			expectedCanonical: "https://xn--bcher-kva.example/",
+			expectedHost:      "xn--bcher-kva.example",
+		},
+		{
+			name:              "resolves decimal IP",
+			message:           "http://3232235777/admin",
+			expectedCanonical: "http://192.168.1.1/admin",
+			expectedHost:      "192.168.1.1",
+			expectedIsIP:      true,
+		},
+		{
+			name:              "resolves hexadecimal IP",
+			message:           "http://0xC0A80101",
+			expectedCanonical: "http://192.168.1.1",
+			expectedHost:      "192.168.1.1",
+			expectedIsIP:      true,
+		},
+		{
+			name:              "resolves octal and short IP",
+			message:           "http://0300.0250.257",
+			expectedCanonical: "http://192.168.1.1",
+			expectedHost:      "192.168.1.1",
+			expectedIsIP:      true,
+		},
+		{
+			name:              "parses the authority of web links without slashes",
+			message:           "[x](https:evil.com/login)",
+			expectedCanonical: "https://evil.com/login",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "parses the authority of web links with a single slash",
+			message:           "https:/evil.com",
+			expectedCanonical: "https://evil.com",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "parses the authority of web links with backslashes",
+			message:           `https:\\evil.com\login`,
+			expectedCanonical: "https://evil.com/login",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "parses the authority of web links with mixed slashes",
+			message:           `ftp:/\\/evil.com`,
+			expectedCanonical: "ftp://evil.com",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "strips the delimiters of autolinks",
+			message:           "<https://evil.com>",
+			expectedCanonical: "https://evil.com",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "strips the delimiters of autolinks followed by text",
+			message:           "See <https://evil.com/path>, then",
+			expectedCanonical: "https://evil.com/path",
+			expectedHost:      "evil.com",
+		},
+		{
+			name:              "keeps port and query",
+			message:           "https://example.com:8443/a?b=c",
+			expectedCanonical: "https://example.com:8443/a?b=c",
+			expectedHost:      "example.com",
+		},
+		{
+			name:              "removes dot segments",
+			message:           "https://example.com/a/./b/../../c/",
+			expectedCanonical: "https://example.com/c/",
+			expectedHost:      "example.com",
+		},
+		{
+			name:              "removes percent-encoded dot segments",
+			message:           "https://example.com/a%2F..%2F%2e%2e/b/..",
+			expectedCanonical: "https://example.com/",
+			expectedHost:      "example.com",
+		},
+		{
+			name:              "handles IPv6 literals",
+			message:           "http://[::FFFF:C0A8:0101]:8080/",
+			expectedCanonical: "http://192.168.1.1:8080/",
+			expectedHost:      "192.168.1.1",
+			expectedIsIP:      true,
+		},
+		{
+			name:              "handles mailto links",
+			message:           "[mail](mailto:plugin@Example.COM)",
+			expectedCanonical: "mailto:plugin@example.com",
+			expectedHost:      "example.com",
+			expectedUserinfo:  "plugin",
+		},
+		{
+			name:              "handles opaque links",
+			message:           "tel:1234",
+			expectedCanonical: "tel:1234",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detectedURLs := p.extractURLs(&model.Post{Message: test.message})
+			require.Len(t, detectedURLs, 1)
+
+			p.canonicalizeURLs(detectedURLs)
+			canonical := detectedURLs[0].canonical
+			require.NotNil(t, canonical)
+			assert.Equal(t, test.expectedCanonical, canonical.String())
+			assert.Equal(t, test.expectedHost, canonical.host)
+			assert.Equal(t, test.expectedUserinfo, canonical.userinfo)
+			assert.Equal(t, test.expectedIsIP, canonical.isIP)
+		})
+	}
+}
+
+func TestParseNumericIPv4(t *testing.T) {
+	for in, expected := range map[string]string{
+		"127.0.0.1":   "127.0.0.1",
+		"2130706433":  "127.0.0.1",
+		"0x7f000001":  "127.0.0.1",
+		"0177.0.0.01": "127.0.0.1",
+		"127.1":       "127.0.0.1",
+		"example.com": "",
+		"1.2.3.4.5":   "",
+		"256.1.1.1":   "",
+		"1_000":       "",
+	} {
+		ip := parseNumericIPv4(in)
+		if expected == "" {
+			assert.Nil(t, ip, in)
+		} else {
+			assert.Equal(t, expected, ip.String(), in)
+		}
+	}
+}
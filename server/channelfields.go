@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Actions for channel headers, purposes and display names containing disallowed links.
+const (
+	channelFieldActionOff    = "off"
+	channelFieldActionRevert = "revert"
+	channelFieldActionWarn   = "warn"
+
+	// Number of channels fetched at once when scanning the channels of a team
+	scanChannelsPerPage = 200
+)
+
+// channelField is a channel field whose changes are announced by a system post.
+type channelField struct {
+	name     string
+	postType string
+	oldProp  string
+	newProp  string
+	get      func(channel *model.Channel) string
+	set      func(channel *model.Channel, value string)
+	// clearable is whether the field can be cleared when a scan finds disallowed links in it.
+	clearable bool
+}
+
+var channelFields = []*channelField{
+	{
+		name:      "header",
+		postType:  model.POST_HEADER_CHANGE,
+		oldProp:   "old_header",
+		newProp:   "new_header",
+		get:       func(channel *model.Channel) string { return channel.Header },
+		set:       func(channel *model.Channel, value string) { channel.Header = value },
+		clearable: true,
+	},
+	{
+		name:      "purpose",
+		postType:  model.POST_PURPOSE_CHANGE,
+		oldProp:   "old_purpose",
+		newProp:   "new_purpose",
+		get:       func(channel *model.Channel) string { return channel.Purpose },
+		set:       func(channel *model.Channel, value string) { channel.Purpose = value },
+		clearable: true,
+	},
+	{
+		name:     "display name",
+		postType: model.POST_DISPLAYNAME_CHANGE,
+		oldProp:  "old_displayname",
+		newProp:  "new_displayname",
+		get:      func(channel *model.Channel) string { return channel.DisplayName },
+		set:      func(channel *model.Channel, value string) { channel.DisplayName = value },
+	},
+}
+
+// channelFieldForPostType returns the channel field whose changes are announced by the post type,
+// or nil if the post type doesn't announce a channel field change.
+func channelFieldForPostType(postType string) *channelField {
+	for _, field := range channelFields {
+		if field.postType == postType {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// filterChannelFieldChange checks the new value of a channel field announced by a system post.
+// If it contains disallowed links, the change is either reverted and the system post rejected, or
+// the user is warned, depending on the configured action.
+func (p *Plugin) filterChannelFieldChange(post *model.Post, field *channelField) (*model.Post, string) {
+	oldValue, _ := post.GetProp(field.oldProp).(string)
+
+	reasons := p.evaluateChannelFieldChange(post, field, time.Now())
+	if len(reasons) == 0 {
+		return post, ""
+	}
+
+	if p.getConfiguration().ChannelFieldAction == channelFieldActionWarn {
+		p.logDecision("Channel "+field.name+" with disallowed links", post, nil, reasons)
+		p.reportViolation(post, false, nil, ruleChannelFields, actionWarn, strings.Join(reasons, " "))
+		p.sendWarning(post, fmt.Sprintf("The channel %s contains links which are not allowed:\n%s", field.name, bulletList(reasons)))
+		return post, ""
+	}
+
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		p.API.LogError("Failed to get the channel to revert its "+field.name, "channel_id", post.ChannelId, "error", appErr.Error())
+		return post, ""
+	}
+	field.set(channel, oldValue)
+	if _, appErr = p.API.UpdateChannel(channel); appErr != nil {
+		p.API.LogError("Failed to revert the channel "+field.name, "channel_id", post.ChannelId, "error", appErr.Error())
+		return post, ""
+	}
+
+	p.logDecision("Channel "+field.name+" reverted by the link filter", post, nil, reasons)
+	p.reportViolation(post, false, nil, ruleChannelFields, actionReject, strings.Join(reasons, " "))
+	p.sendWarning(post, fmt.Sprintf("The channel %s has been reverted by the Link Filter:\n%s", field.name, bulletList(reasons)))
+	return nil, fmt.Sprintf("Channel %s reverted: %s", field.name, strings.Join(reasons, " "))
+}
+
+// monitorChannelFieldChange logs the disallowed links of the new value of a channel field while
+// the monitor mode is on, without reverting the change or warning the user.
+func (p *Plugin) monitorChannelFieldChange(post *model.Post, field *channelField, now time.Time) (*model.Post, string) {
+	if reasons := p.evaluateChannelFieldChange(post, field, now); len(reasons) > 0 {
+		p.logDecision("Channel "+field.name+" allowed by the monitor mode of the link filter", post, nil, reasons)
+	}
+
+	return post, ""
+}
+
+// evaluateChannelFieldChange returns the reasons why the new value of the channel field announced
+// by the system post contains disallowed links.
+func (p *Plugin) evaluateChannelFieldChange(post *model.Post, field *channelField, now time.Time) []string {
+	newValue, _ := post.GetProp(field.newProp).(string)
+
+	return p.evaluatePost(&model.Post{
+		UserId:    post.UserId,
+		ChannelId: post.ChannelId,
+		Message:   newValue,
+	}, now)
+}
+
+// scanChannel checks the fields of the channel for disallowed links. If the configured action is
+// to revert, fields which can be cleared are cleared. It returns a description of each field with
+// disallowed links.
+func (p *Plugin) scanChannel(channel *model.Channel) []string {
+	revert := p.getConfiguration().ChannelFieldAction == channelFieldActionRevert
+
+	var findings []string
+	changed := false
+	for _, field := range channelFields {
+		reasons := p.evaluatePost(&model.Post{
+			UserId:    channel.CreatorId,
+			ChannelId: channel.Id,
+			Message:   field.get(channel),
+		}, time.Now())
+		if len(reasons) == 0 {
+			continue
+		}
+
+		finding := fmt.Sprintf("~%s %s: %s", channel.Name, field.name, strings.Join(reasons, " "))
+		if revert && field.clearable {
+			field.set(channel, "")
+			changed = true
+			finding += " (cleared)"
+		}
+		findings = append(findings, finding)
+	}
+
+	if changed {
+		if _, appErr := p.API.UpdateChannel(channel); appErr != nil {
+			p.API.LogError("Failed to clear the channel fields with disallowed links", "channel_id", channel.Id, "error", appErr.Error())
+			return append(findings, fmt.Sprintf("~%s: failed to clear the fields: %s", channel.Name, appErr.Error()))
+		}
+	}
+
+	return findings
+}
+
+// executeScanCommand scans the public channels of the team, and the channel the command is run in,
+// for channel fields with disallowed links.
+func (p *Plugin) executeScanCommand(args *model.CommandArgs, _ []string) string {
+	channels := map[string]*model.Channel{}
+	for page := 0; ; page++ {
+		teamChannels, appErr := p.API.GetPublicChannelsForTeam(args.TeamId, page, scanChannelsPerPage)
+		if appErr != nil {
+			return fmt.Sprintf("Failed to get the channels of the team: %s", appErr.Error())
+		}
+		for _, channel := range teamChannels {
+			channels[channel.Id] = channel
+		}
+		if len(teamChannels) < scanChannelsPerPage {
+			break
+		}
+	}
+	if _, ok := channels[args.ChannelId]; !ok {
+		if channel, appErr := p.API.GetChannel(args.ChannelId); appErr == nil {
+			channels[channel.Id] = channel
+		}
+	}
+
+	var findings []string
+	for _, channel := range channels {
+		findings = append(findings, p.scanChannel(channel)...)
+	}
+	if len(findings) == 0 {
+		return fmt.Sprintf("Scanned %d channels, no disallowed links found.", len(channels))
+	}
+	sort.Strings(findings)
+
+	return fmt.Sprintf("Scanned %d channels, found disallowed links in:\n%s", len(channels), bulletList(findings))
+}
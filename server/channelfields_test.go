@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestFilterChannelFieldChange(t *testing.T) {
+	newPlugin := func(action string) (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "http,https", "http,https", ""), &mockAPI{channels: map[string]*model.Channel{
+			"channel1": {Id: "channel1", Name: "town-square", Header: "[evil](s3://bucket)", Purpose: "old purpose"},
+		}}, func(c *configuration) { c.ChannelFieldAction = action })
+	}
+	headerChange := func(oldHeader, newHeader string) *model.Post {
+		post := &model.Post{
+			UserId:    "user1",
+			ChannelId: "channel1",
+			Type:      model.POST_HEADER_CHANGE,
+			Message:   "user1 updated the channel header from: " + oldHeader + " to: " + newHeader,
+		}
+		post.AddProp("old_header", oldHeader)
+		post.AddProp("new_header", newHeader)
+		return post
+	}
+
+	t.Run("allows headers with allowed links", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionRevert)
+
+		post, errMessage := p.MessageWillBePosted(nil, headerChange("old header", "See https://example.com"))
+		assert.NotNil(t, post)
+		assert.Empty(t, errMessage)
+		assert.Nil(t, mockAPI.sentEphemeralPost)
+	})
+
+	t.Run("reverts headers with disallowed links", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionRevert)
+
+		post, errMessage := p.MessageWillBePosted(nil, headerChange("old header", "[evil](s3://bucket)"))
+		assert.Nil(t, post)
+		assert.Equal(t, "Channel header reverted: Schemes not allowed: s3", errMessage)
+		assert.Equal(t, "old header", mockAPI.channels["channel1"].Header)
+		require.NotNil(t, mockAPI.sentEphemeralPost)
+		assert.Contains(t, mockAPI.sentEphemeralPost.Message, "The channel header has been reverted")
+	})
+
+	t.Run("reverts purposes with disallowed links", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionRevert)
+		p.configuration.MismatchedLinkTextAction = actionReject
+
+		post := &model.Post{UserId: "user1", ChannelId: "channel1", Type: model.POST_PURPOSE_CHANGE}
+		post.AddProp("old_purpose", "old purpose")
+		post.AddProp("new_purpose", "[bank.com](https://evil.com)")
+		_, errMessage := p.MessageWillBePosted(nil, post)
+		assert.Contains(t, errMessage, "Channel purpose reverted")
+		assert.Equal(t, "old purpose", mockAPI.channels["channel1"].Purpose)
+	})
+
+	t.Run("warns about headers with disallowed links", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionWarn)
+
+		post, errMessage := p.MessageWillBePosted(nil, headerChange("old header", "[evil](s3://bucket)"))
+		assert.NotNil(t, post)
+		assert.Empty(t, errMessage)
+		assert.Equal(t, "[evil](s3://bucket)", mockAPI.channels["channel1"].Header)
+		require.NotNil(t, mockAPI.sentEphemeralPost)
+		assert.Contains(t, mockAPI.sentEphemeralPost.Message, "The channel header contains links which are not allowed")
+	})
+
+	t.Run("only logs headers with disallowed links in monitor mode", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionRevert)
+		now := time.Now()
+		p.setMonitorMode(&monitorMode{Reason: "testing", Since: now.UnixMilli()}, now)
+
+		post, errMessage := p.MessageWillBePosted(nil, headerChange("old header", "[evil](s3://bucket)"))
+		assert.NotNil(t, post)
+		assert.Empty(t, errMessage)
+		assert.Equal(t, "[evil](s3://bucket)", mockAPI.channels["channel1"].Header)
+		assert.Nil(t, mockAPI.sentEphemeralPost)
+		require.Len(t, mockAPI.infoLogs, 1)
+		assert.Equal(t, "Channel header allowed by the monitor mode of the link filter", mockAPI.infoLogs[0].message)
+	})
+
+	t.Run("filters the system post as a regular post when off", func(t *testing.T) {
+		p, _ := newPlugin(channelFieldActionOff)
+
+		_, errMessage := p.MessageWillBePosted(nil, headerChange("old header", "[evil](s3://bucket)"))
+		assert.Equal(t, "Schemes not allowed: s3", errMessage)
+	})
+}
+
+func TestScanCommand(t *testing.T) {
+	newPlugin := func(action string) (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "http,https", "http,https", ""), &mockAPI{
+			admins: map[string]bool{"admin": true},
+			channels: map[string]*model.Channel{
+				"clean":   {Id: "clean", TeamId: "team1", Name: "clean", Type: model.CHANNEL_OPEN, Header: "https://example.com"},
+				"header":  {Id: "header", TeamId: "team1", Name: "header", Type: model.CHANNEL_OPEN, Header: "[evil](s3://bucket)"},
+				"private": {Id: "private", TeamId: "team1", Name: "private", Type: model.CHANNEL_PRIVATE, DisplayName: "ftp://evil.com"},
+				"other":   {Id: "other", TeamId: "team2", Name: "other", Type: model.CHANNEL_OPEN, Header: "[evil](s3://bucket)"},
+			},
+		}, func(c *configuration) { c.ChannelFieldAction = action })
+	}
+	scan := func(p *Plugin, userID, channelID string) string {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter scan", UserId: userID, TeamId: "team1", ChannelId: channelID})
+		require.Nil(t, appErr)
+		return response.Text
+	}
+
+	t.Run("reports the channels with disallowed links", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionWarn)
+
+		assert.Equal(t, "Scanned 3 channels, found disallowed links in:\n"+
+			"* ~header header: Schemes not allowed: s3\n"+
+			"* ~private display name: Schemes not allowed: ftp", scan(p, "admin", "private"))
+		assert.Equal(t, "[evil](s3://bucket)", mockAPI.channels["header"].Header)
+	})
+
+	t.Run("clears the headers with disallowed links when reverting", func(t *testing.T) {
+		p, mockAPI := newPlugin(channelFieldActionRevert)
+
+		assert.Equal(t, "Scanned 2 channels, found disallowed links in:\n"+
+			"* ~header header: Schemes not allowed: s3 (cleared)", scan(p, "admin", "clean"))
+		assert.Empty(t, mockAPI.channels["header"].Header)
+		assert.Equal(t, "[evil](s3://bucket)", mockAPI.channels["other"].Header)
+	})
+
+	t.Run("is only available to system admins", func(t *testing.T) {
+		p, _ := newPlugin(channelFieldActionWarn)
+
+		assert.Equal(t, "Only system admins can manage the link filter.", scan(p, "user1", "clean"))
+	})
+}
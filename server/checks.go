@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Actions that can be configured for the checks run on each detected URL.
+const (
+	actionOff    = "off"
+	actionReject = "reject"
+	actionWarn   = "warn"
+	actionDefang = "defang"
+	// actionRewrite replaces the link with another URL. It's only given by the URL checkers.
+	actionRewrite = "rewrite"
+
+	// Message sent to the user when a post is allowed but one of its links raised a warning
+	CheckWarningMessage = "Please be careful with the links in your post:"
+)
+
+// violation describes a detected URL that failed one of the checks, and what should be done
+// about it.
+type violation struct {
+	url    *detectedURL
+	check  string
+	action string
+	reason string
+	// rewrite is the URL the link is replaced with, for actionRewrite.
+	rewrite string
+}
+
+// urlCheck inspects a single detected URL and returns a violation if the URL fails the check.
+type urlCheck func(u *detectedURL, post *model.Post) *violation
+
+// urlCheckers returns the checkers to run on each detected URL at the given time, based on the
+// plugin configuration and the schedules of the checks. The built-in checks run first, then the
+// reputation checkers.
+func (p *Plugin) urlCheckers(now time.Time) []URLChecker {
+	configuration := p.getConfiguration()
+
+	var checkers []URLChecker
+	addCheck := func(name string, check urlCheck) {
+		checkers = append(checkers, &checkerFunc{name: name, check: check})
+	}
+	if isActionEnabled(configuration.HomoglyphAction) && p.isRuleActive(checkHomoglyph, now) {
+		addCheck(checkHomoglyph, p.checkHomoglyphs)
+	}
+	if isActionEnabled(configuration.MismatchedLinkTextAction) && p.isRuleActive(checkMismatchedLinkText, now) {
+		addCheck(checkMismatchedLinkText, p.checkMismatchedLinkText)
+	}
+	if isActionEnabled(configuration.IPLinkAction) && p.isRuleActive(checkIPAddresses, now) {
+		addCheck(checkIPAddresses, p.checkIPAddresses)
+	}
+	if isActionEnabled(configuration.PortAction) && len(configuration.allowedPorts) > 0 && p.isRuleActive(checkPorts, now) {
+		addCheck(checkPorts, p.checkPorts)
+	}
+	if isActionEnabled(configuration.FileExtensionAction) && len(configuration.blockedFileExtensions) > 0 && p.isRuleActive(checkFileExtensions, now) {
+		addCheck(checkFileExtensions, p.checkFileExtensions)
+	}
+	if isActionEnabled(configuration.URLRuleAction) && len(configuration.urlRules) > 0 && p.isRuleActive(checkURLRules, now) {
+		addCheck(checkURLRules, p.checkURLRules)
+	}
+	if isActionEnabled(configuration.UnresolvedShortLinkAction) && len(configuration.shortenerHosts) > 0 && p.isRuleActive(checkShortLinks, now) {
+		addCheck(checkShortLinks, p.checkShortLinks)
+	}
+	if isActionEnabled(configuration.ReputationAction) && p.isRuleActive(checkReputation, now) {
+		checkers = append(checkers, configuration.reputationCheckers...)
+	}
+
+	return checkers
+}
+
+// runChecks runs the configured checkers on all the URLs which haven't been rewritten and returns
+// the violations found, in the order of the URLs.
+//
+// The URLs are checked in parallel, at most maxParallelURLChecks at a time, within the configured
+// checker timeout for the whole post.
+func (p *Plugin) runChecks(detectedURLs []*detectedURL, post *model.Post, now time.Time) []*violation {
+	checkers := p.urlCheckers(now)
+	if len(checkers) == 0 {
+		return nil
+	}
+
+	timeout := time.Duration(p.getConfiguration().CheckerTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultCheckerTimeout
+	}
+	ctx, cancel := context.WithTimeout(contextWithPost(context.Background(), post), timeout)
+	defer cancel()
+
+	found := make([][]*violation, len(detectedURLs))
+	parallel := make(chan struct{}, maxParallelURLChecks)
+	var wg sync.WaitGroup
+	for i, u := range detectedURLs {
+		if u.rewritten {
+			continue
+		}
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+
+		wg.Add(1)
+		go func(i int, u *detectedURL) {
+			defer wg.Done()
+			parallel <- struct{}{}
+			defer func() { <-parallel }()
+
+			for _, checker := range checkers {
+				if verdict := p.runChecker(ctx, checker, u); isActionEnabled(verdict.Action) {
+					found[i] = append(found[i], &violation{
+						url:     u,
+						check:   checker.Name(),
+						action:  verdict.Action,
+						reason:  verdict.Reason,
+						rewrite: verdict.Rewrite,
+					})
+				}
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	var violations []*violation
+	for _, urlViolations := range found {
+		violations = append(violations, urlViolations...)
+	}
+
+	return violations
+}
+
+// evaluatePost runs the policies on a copy of the post without any side effect, as if it was posted
+// at the given time, and returns the reasons why the links of the post would be rejected or
+// defanged. It's used to check texts which aren't posted as messages, like channel headers.
+func (p *Plugin) evaluatePost(post *model.Post, now time.Time) []string {
+	post = post.Clone()
+	detectedURLs := p.extractURLs(post)
+	p.canonicalizeURLs(detectedURLs)
+	post.Message = p.rewriteLinks(detectedURLs, post)
+	p.cleanupLinks(detectedURLs, post)
+	p.resolveShortLinks(detectedURLs)
+
+	if invalidURLProtocols := p.getInvalidProtocols(detectedURLs, post, now); len(invalidURLProtocols) > 0 {
+		return []string{fmt.Sprintf("Schemes not allowed: %s", strings.Join(invalidURLProtocols, ", "))}
+	}
+
+	reasons := p.checkLinkLimits(detectedURLs, post, now)
+	for _, v := range p.runChecks(detectedURLs, post, now) {
+		if v.action == actionReject || v.action == actionDefang {
+			reasons = append(reasons, v.reason)
+		}
+	}
+
+	return reasons
+}
+
+// applyViolations carries out the action of each violation. If any of the violations rejects the
+// post, it sends an ephemeral post to the user and returns the rejection reason. Defanged links
+// are rewritten in the post message, and warnings are sent to the user as an ephemeral post.
+func (p *Plugin) applyViolations(detectedURLs []*detectedURL, violations []*violation, post *model.Post, isEdit bool) string {
+	if len(violations) == 0 {
+		return ""
+	}
+
+	var rejected, warnings []string
+	var urls []*detectedURL
+	for _, v := range violations {
+		urls = append(urls, v.url)
+		p.reportViolation(post, isEdit, v.url, v.check, v.action, v.reason)
+		switch v.action {
+		case actionReject:
+			rejected = append(rejected, v.reason)
+		case actionDefang:
+			p.defangDetectedURL(post, detectedURLs, v.url)
+			warnings = append(warnings, v.reason)
+		case actionRewrite:
+			p.updateDetectedURL(post, detectedURLs, v.url, v.rewrite)
+			warnings = append(warnings, v.reason)
+		case actionWarn:
+			warnings = append(warnings, v.reason)
+		}
+	}
+
+	if len(rejected) > 0 {
+		p.rejectPost(post, isEdit, urls, rejected)
+		return fmt.Sprintf("Links not allowed: %s", strings.Join(rejected, " "))
+	}
+
+	p.logDecision("Post allowed with warnings by the link filter", post, urls, warnings)
+	p.sendWarning(post, CheckWarningMessage+"\n"+bulletList(warnings))
+	return ""
+}
+
+// rejectPost logs the rejection of the post and sends the warning message with the reasons of
+// the rejection to the user.
+func (p *Plugin) rejectPost(post *model.Post, isEdit bool, detectedURLs []*detectedURL, reasons []string) {
+	p.logDecision("Post rejected by the link filter", post, detectedURLs, reasons)
+	p.sendWarning(post, p.warningMessage(isEdit)+"\n"+bulletList(reasons))
+}
+
+// defangDetectedURL rewrites the detected URL in the post message, at the position it was
+// detected, so it isn't autolinked. Embedded links are replaced by their text followed by the
+// defanged URL, keeping the text in place so the links found in it can still be defanged.
+func (p *Plugin) defangDetectedURL(post *model.Post, detectedURLs []*detectedURL, u *detectedURL) {
+	if u.rewritten {
+		return
+	}
+	u.rewritten = true
+
+	if u.isPlainText {
+		post.Message = replaceText(post.Message, detectedURLs, u, u.positions[0], u.positions[1], defangURL(u))
+		return
+	}
+	post.Message = replaceText(post.Message, detectedURLs, u, u.positions[3], u.positions[1], " "+defangURL(u))
+	post.Message = replaceText(post.Message, detectedURLs, u, u.positions[0], u.positions[2], "")
+}
+
+// defangURL returns the URL part of the detected URL in a form that won't be autolinked, using
+// the same format as the rewrite protocols list. e.g. https://evil.com becomes https(evil.com)
+func defangURL(u *detectedURL) string {
+	rest := strings.TrimPrefix(u.rawURL, u.protocol+":")
+	rest = strings.TrimPrefix(rest, "//")
+
+	return fmt.Sprintf("%s(%s)", u.protocol, rest)
+}
+
+func (p *Plugin) warningMessage(isEdit bool) string {
+	configuration := p.getConfiguration()
+	if isEdit {
+		return configuration.EditPostWarningMessage
+	}
+
+	return configuration.CreatePostWarningMessage
+}
+
+func (p *Plugin) sendWarning(post *model.Post, message string) {
+	p.API.SendEphemeralPost(post.UserId, &model.Post{
+		ChannelId: post.ChannelId,
+		Message:   message,
+		RootId:    post.RootId,
+	})
+}
+
+func isActionEnabled(action string) bool {
+	return action != "" && action != actionOff
+}
+
+func bulletList(items []string) string {
+	return "* " + strings.Join(items, "\n* ")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+// maxRedirectUnwraps limits how many nested redirect wrappers are unwrapped from a single link.
+const maxRedirectUnwraps = 5
+
+// defaultStrippedQueryParameters are the tracking parameters removed from links when link cleanup
+// is enabled. A trailing * matches any parameter starting with the prefix.
+var defaultStrippedQueryParameters = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid", "igshid", "mc_cid", "mc_eid", "_hsenc", "_hsmi",
+}
+
+// defaultRedirectWrappers are the known redirectors unwrapped when link cleanup is enabled, in the
+// same host/path?parameter format used by the Redirect Wrappers setting.
+var defaultRedirectWrappers = []string{
+	"l.facebook.com/l.php?u",
+	"lm.facebook.com/l.php?u",
+	"www.google.com/url?q",
+	"google.com/url?q",
+	"www.youtube.com/redirect?q",
+	"slack-redir.net/link?url",
+}
+
+// redirectWrapper describes a redirector which passes the target URL in a query parameter.
+type redirectWrapper struct {
+	host string
+	// path is empty if any path on the host is a redirect.
+	path      string
+	parameter string
+}
+
+// parseRedirectWrappers parses a list of redirectors in the host/path?parameter format.
+func parseRedirectWrappers(list []string) []redirectWrapper {
+	var wrappers []redirectWrapper
+	for _, entry := range util.TrimString(list) {
+		location, parameter, found := strings.Cut(entry, "?")
+		if !found || parameter == "" {
+			continue
+		}
+		host, path, _ := strings.Cut(location, "/")
+		if path != "" {
+			path = "/" + path
+		}
+		wrappers = append(wrappers, redirectWrapper{
+			host:      strings.ToLower(host),
+			path:      path,
+			parameter: parameter,
+		})
+	}
+
+	return wrappers
+}
+
+// cleanupLinks removes tracking parameters from the links in the post and unwraps known redirect
+// wrappers, rewriting the post message in place. Links rewritten to prevent autolinking are left
+// untouched. It runs before the policies are evaluated, so they apply to the final target.
+func (p *Plugin) cleanupLinks(detectedURLs []*detectedURL, post *model.Post) {
+	if !p.getConfiguration().CleanupLinks {
+		return
+	}
+
+	for _, u := range detectedURLs {
+		if u.rewritten {
+			continue
+		}
+
+		cleaned := p.cleanupURL(u.rawURL)
+		if cleaned != u.rawURL {
+			p.updateDetectedURL(post, detectedURLs, u, cleaned)
+			u.cleaned = true
+		}
+	}
+}
+
+// cleanupURL returns the raw URL without its tracking parameters and redirect wrappers. URLs
+// which can't be parsed are returned as is.
+func (p *Plugin) cleanupURL(rawURL string) string {
+	for i := 0; i < maxRedirectUnwraps; i++ {
+		target := p.unwrapRedirect(rawURL)
+		if target == "" {
+			break
+		}
+		rawURL = target
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.RawQuery == "" {
+		return rawURL
+	}
+
+	// Filter the raw query instead of re-encoding it, to keep the order and encoding of the
+	// remaining parameters.
+	var kept []string
+	for _, parameter := range strings.Split(parsed.RawQuery, "&") {
+		key, _, _ := strings.Cut(parameter, "=")
+		if decoded, err := url.QueryUnescape(key); err == nil {
+			key = decoded
+		}
+		if !p.isStrippedQueryParameter(key) {
+			kept = append(kept, parameter)
+		}
+	}
+
+	query := strings.Join(kept, "&")
+	if query == parsed.RawQuery {
+		return rawURL
+	}
+
+	prefix, rest, _ := strings.Cut(rawURL, "?")
+	if _, fragment, found := strings.Cut(rest, "#"); found {
+		if query == "" {
+			return prefix + "#" + fragment
+		}
+		return prefix + "?" + query + "#" + fragment
+	}
+	if query == "" {
+		return prefix
+	}
+	return prefix + "?" + query
+}
+
+// unwrapRedirect returns the target of the redirect wrapper, or an empty string if the URL isn't a
+// known redirect wrapper or the target isn't an http(s) URL.
+func (p *Plugin) unwrapRedirect(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, wrapper := range p.getConfiguration().redirectWrappers {
+		if wrapper.host != host || (wrapper.path != "" && wrapper.path != parsed.Path) {
+			continue
+		}
+
+		target, err := url.Parse(parsed.Query().Get(wrapper.parameter))
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return ""
+		}
+		return target.String()
+	}
+
+	return ""
+}
+
+func (p *Plugin) isStrippedQueryParameter(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range p.getConfiguration().strippedQueryParameters {
+		if prefix, isPrefix := strings.CutSuffix(pattern, "*"); isPrefix {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateDetectedURL replaces the URL part of the detected URL in the post message with the new
+// URL, at the position the URL was detected, and updates the detected URL so later stages see the
+// new URL.
+func (p *Plugin) updateDetectedURL(post *model.Post, detectedURLs []*detectedURL, u *detectedURL, newURL string) {
+	start, end := u.urlPositions()
+	post.Message = replaceText(post.Message, detectedURLs, u, start, end, newURL)
+
+	if scheme, _, found := strings.Cut(newURL, ":"); found {
+		u.protocol = scheme
+	}
+	u.rawURL = newURL
+	u.host = strings.TrimPrefix(strings.TrimPrefix(newURL, u.protocol+":"), "//")
+	u.canonical = canonicalize(u.protocol, u.rawURL)
+
+	// The scheme and host groups are the last two groups of both regular expressions
+	groups := len(u.positions)
+	u.positions[groups-4] = start
+	u.positions[groups-3] = start + len(u.protocol)
+	u.positions[groups-2] = start + len(newURL) - len(u.host)
+	u.positions[groups-1] = start + len(newURL)
+	u.originalText = post.Message[u.positions[0]:u.positions[1]]
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCleanupLinks(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "tel")
+	p.configuration.CleanupLinks = true
+	p.configuration.StripQueryParameters = "ref, pk_*"
+	p.configuration.RedirectWrappers = "go.example.com?to"
+	require.NoError(t, p.initConfiguration(p.configuration))
+
+	var tests = []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{
+			name:     "keeps links without tracking parameters",
+			message:  "See https://example.com/page?id=1&lang=en",
+			expected: "See https://example.com/page?id=1&lang=en",
+		},
+		{
+			name:     "strips utm parameters from plain links",
+			message:  "See https://example.com/page?utm_source=news&id=1&utm_medium=email",
+			expected: "See https://example.com/page?id=1",
+		},
+		{
+			name:     "strips tracking parameters from embedded links",
+			message:  "See [the page](https://example.com/page?fbclid=abc&gclid=def#top)",
+			expected: "See [the page](https://example.com/page#top)",
+		},
+		{
+			name:     "strips configured parameters",
+			message:  "https://example.com/?ref=home&pk_campaign=x&q=search",
+			expected: "https://example.com/?q=search",
+		},
+		{
+			name:     "unwraps known redirectors",
+			message:  "[link](https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Fpage%3Futm_source%3Dg%26id%3D2&sa=D)",
+			expected: "[link](https://example.com/page?id=2)",
+		},
+		{
+			name:     "unwraps configured redirectors on any path",
+			message:  "https://go.example.com/some/path?to=https://target.example.com/",
+			expected: "https://target.example.com/",
+		},
+		{
+			name:     "doesn't unwrap non-http targets",
+			message:  "https://www.google.com/url?q=javascript:alert(1)",
+			expected: "https://www.google.com/url?q=javascript:alert(1)",
+		},
+		{
+			name:     "cleans up the destination of links showing their own URL",
+			message:  "[https://x.com/?utm_source=1](https://x.com/?utm_source=1)",
+			expected: "[https://x.com/](https://x.com/)",
+		},
+		{
+			name:     "cleans up each link at its own position",
+			message:  "https://x.com/?id=1 and [site](https://x.com/?id=1&utm_source=1) then https://y.com/?gclid=2 https://x.com/?id=1",
+			expected: "https://x.com/?id=1 and [site](https://x.com/?id=1) then https://y.com/ https://x.com/?id=1",
+		},
+		{
+			name:     "leaves rewritten links untouched",
+			message:  "tel://123?utm_source=x",
+			expected: "tel(123?utm_source=x)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			post := &model.Post{Message: test.message}
+			detectedURLs := p.extractURLs(post)
+			p.canonicalizeURLs(detectedURLs)
+			post.Message = p.rewriteLinks(detectedURLs, post)
+			p.cleanupLinks(detectedURLs, post)
+
+			assert.Equal(t, test.expected, post.Message)
+		})
+	}
+
+	t.Run("policies see the unwrapped target", func(t *testing.T) {
+		post := &model.Post{Message: "https://go.example.com/?to=https://evil.example.com/login"}
+		detectedURLs := p.extractURLs(post)
+		p.canonicalizeURLs(detectedURLs)
+		p.cleanupLinks(detectedURLs, post)
+
+		require.Len(t, detectedURLs, 1)
+		assert.Equal(t, "evil.example.com", detectedURLs[0].canonical.host)
+	})
+
+	t.Run("doesn't clean up a copy of the link shown earlier as link text", func(t *testing.T) {
+		p2 := newTestPlugin(t, true, "http,https", "http,https", "")
+		p2.configuration.CleanupLinks = true
+		p2.configuration.MismatchedLinkTextAction = actionWarn
+		require.NoError(t, p2.initConfiguration(p2.configuration))
+		post := &model.Post{Message: "[see https://x.com/?utm_source=1](https://y.com) https://x.com/?utm_source=1"}
+		detectedURLs := p2.extractURLs(post)
+		p2.canonicalizeURLs(detectedURLs)
+		p2.cleanupLinks(detectedURLs, post)
+
+		assert.Equal(t, "[see https://x.com/?utm_source=1](https://y.com) https://x.com/", post.Message)
+	})
+
+	t.Run("does nothing when disabled", func(t *testing.T) {
+		p2 := newTestPlugin(t, true, "http,https", "http,https", "")
+		post := &model.Post{Message: "https://example.com/?utm_source=x"}
+		detectedURLs := p2.extractURLs(post)
+		p2.cleanupLinks(detectedURLs, post)
+
+		assert.Equal(t, "https://example.com/?utm_source=x", post.Message)
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const commandTrigger = "linkfilter"
+
+// commandHandler executes a subcommand of /linkfilter with the parameters following the
+// subcommand, and returns the text sent back to the user.
+type commandHandler struct {
+	hint     string
+	helpText string
+	execute  func(args *model.CommandArgs, parameters []string) string
+}
+
+// commandHandlers returns the subcommands of /linkfilter by name.
+func (p *Plugin) commandHandlers() map[string]commandHandler {
+	return map[string]commandHandler{
+		"export": {
+			hint:     "",
+			helpText: "Export the full configuration as a policy document",
+			execute:  p.executeExportCommand,
+		},
+		"history": {
+			hint:     "",
+			helpText: "List the latest changes of the configuration",
+			execute:  p.executeHistoryCommand,
+		},
+		"import": {
+			hint:     "[--apply] <document>",
+			helpText: "Show the changes a policy document would make to the configuration, and apply them with --apply",
+			execute:  p.executeImportCommand,
+		},
+		"monitor": {
+			hint:     "[on|off]",
+			helpText: "Show whether the filter only logs its decisions, or switch the monitor mode on or off",
+			execute:  p.executeMonitorCommand,
+		},
+		"rollback": {
+			hint:     "<version>",
+			helpText: "Restore a version of the configuration listed by history",
+			execute:  p.executeRollbackCommand,
+		},
+		"scan": {
+			hint:     "",
+			helpText: "Scan the channel headers, purposes and display names of the team for disallowed links",
+			execute:  p.executeScanCommand,
+		},
+		"shortlink": {
+			hint:     "[add <short link> <destination>|remove <short link>]",
+			helpText: "List the short links resolved by the link filter without network access, or add or remove one",
+			execute:  p.executeShortLinkCommand,
+		},
+		"test": {
+			hint:     "[--at <time>] <message>",
+			helpText: "Check the links of a message as if you posted it in this channel, optionally at another time given in RFC 3339 format",
+			execute:  p.executeTestCommand,
+		},
+	}
+}
+
+// registerCommands registers the /linkfilter slash command.
+func (p *Plugin) registerCommands() error {
+	autocomplete := model.NewAutocompleteData(commandTrigger, "[command]", "Manage the link filter")
+	handlers := p.commandHandlers()
+	for _, name := range sortedCommandNames(handlers) {
+		autocomplete.AddCommand(model.NewAutocompleteData(name, handlers[name].hint, handlers[name].helpText))
+	}
+
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Link Filter",
+		Description:      "Manage the link filter.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: " + strings.Join(sortedCommandNames(handlers), ", "),
+		AutoCompleteHint: "[command]",
+		AutocompleteData: autocomplete,
+	}); err != nil {
+		return errors.Wrap(err, "failed to register the slash command")
+	}
+
+	return nil
+}
+
+// commandText returns the text of the command following its first fields, as typed by the user.
+// Unlike the parameters, the spaces and line breaks of the text are kept.
+func commandText(command string, fields int) string {
+	text := command
+	for i := 0; i < fields; i++ {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		end := strings.IndexFunc(text, unicode.IsSpace)
+		if end < 0 {
+			return ""
+		}
+		text = text[end:]
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// ExecuteCommand executes the /linkfilter slash command. It's only available to system admins.
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return ephemeralResponse("Only system admins can manage the link filter."), nil
+	}
+
+	fields := strings.Fields(args.Command)
+	handlers := p.commandHandlers()
+	if len(fields) < 2 {
+		return ephemeralResponse(commandHelp(handlers)), nil
+	}
+
+	handler, ok := handlers[fields[1]]
+	if !ok {
+		return ephemeralResponse(fmt.Sprintf("Unknown command `%s`.\n%s", fields[1], commandHelp(handlers))), nil
+	}
+
+	return ephemeralResponse(handler.execute(args, fields[2:])), nil
+}
+
+// executeTestCommand evaluates the message given as parameter without posting it, at the current
+// time or at the time given with --at, so scheduled rules can be checked.
+func (p *Plugin) executeTestCommand(args *model.CommandArgs, parameters []string) string {
+	now := time.Now()
+	// Skip the trigger and the subcommand, and --at with its time
+	fields := 2
+	if len(parameters) >= 2 && parameters[0] == "--at" {
+		at, err := time.Parse(time.RFC3339, parameters[1])
+		if err != nil {
+			return fmt.Sprintf("Invalid time `%s`, expected a time like `2006-01-02T15:04:05+07:00`.", parameters[1])
+		}
+		now = at
+		fields += 2
+	}
+	// The message is read from the raw command, so the spaces and line breaks are kept
+	message := commandText(args.Command, fields)
+	if message == "" {
+		return fmt.Sprintf("Please provide a message to test, e.g. `/%s test [--at <time>] <message>`.", commandTrigger)
+	}
+
+	post := &model.Post{
+		UserId:    args.UserId,
+		ChannelId: args.ChannelId,
+		Message:   message,
+	}
+	result := fmt.Sprintf("Evaluated at %s with the `%s` policy.\n", now.Format(time.RFC3339), p.protocolPolicyForPost(post, now).name)
+	reasons := p.evaluatePost(post, now)
+	if len(reasons) == 0 {
+		return result + "The message would be allowed."
+	}
+
+	return result + "The links of the message would be rejected or defanged:\n" + bulletList(reasons)
+}
+
+func commandHelp(handlers map[string]commandHandler) string {
+	var builder strings.Builder
+	builder.WriteString("Available commands:")
+	for _, name := range sortedCommandNames(handlers) {
+		handler := handlers[name]
+		usage := strings.TrimSpace(fmt.Sprintf("/%s %s %s", commandTrigger, name, handler.hint))
+		builder.WriteString(fmt.Sprintf("\n* `%s` - %s", usage, handler.helpText))
+	}
+
+	return builder.String()
+}
+
+func sortedCommandNames(handlers map[string]commandHandler) []string {
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         text,
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestExecuteCommandHelp(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.API = &mockAPI{admins: map[string]bool{"admin": true}}
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter", UserId: "admin"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "* `/linkfilter scan` - Scan the channel headers")
+
+	response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter unknown", UserId: "admin"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "Unknown command `unknown`.")
+}
+
+func TestExecuteTestCommand(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.MaxPlainLinkDensity = 50
+	p.API = &mockAPI{admins: map[string]bool{"admin": true}}
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter test", UserId: "admin"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "Please provide a message to test")
+
+	// The spaces and line breaks count in the length of the message, like in a post
+	message := "https://a.example.com\n" + strings.Repeat(" ", 50) + "\nhttps://b.example.com"
+	response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter test " + message, UserId: "admin"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "The message would be allowed.")
+
+	response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter test https://a.example.com https://b.example.com", UserId: "admin"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "Plain text links make up 97% of the post, the maximum is 50%.")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	configHistoryKeyPrefix = "confighistory_"
+	configHistoryLatestKey = configHistoryKeyPrefix + "latest"
+
+	// maxConfigHistory is the number of configuration versions kept in the history.
+	maxConfigHistory = 100
+	// configHistoryListed is the number of versions listed by /linkfilter history.
+	configHistoryListed = 10
+	// configHistoryRetries is how many times recording a version is retried when another server
+	// of the cluster recorded a version concurrently.
+	configHistoryRetries = 5
+)
+
+// configVersion is a configuration seen by OnConfigurationChange, with the changes from the
+// previous version. The secrets are never recorded.
+type configVersion struct {
+	Version       int             `json:"version"`
+	Timestamp     int64           `json:"timestamp"`
+	Configuration *configuration  `json:"configuration"`
+	Changes       []settingChange `json:"changes"`
+}
+
+func configHistoryKey(version int) string {
+	return configHistoryKeyPrefix + strconv.Itoa(version)
+}
+
+// recordConfiguration records the configuration as a new version in the history, unless it's the
+// same as the latest version. Every server of a cluster calls OnConfigurationChange, so versions
+// are created atomically and only the first server records a change. Changes of the secrets alone
+// aren't recorded.
+func (p *Plugin) recordConfiguration(configuration *configuration, now time.Time) error {
+	configuration = withoutSecrets(configuration)
+	for i := 0; i < configHistoryRetries; i++ {
+		latest, err := p.getLatestConfigVersion()
+		if err != nil {
+			return err
+		}
+
+		version := &configVersion{
+			Version:       1,
+			Timestamp:     now.UnixMilli(),
+			Configuration: configuration,
+		}
+		if latest != nil {
+			version.Changes = diffConfigurations(latest.Configuration, configuration)
+			if len(version.Changes) == 0 {
+				return nil
+			}
+			version.Version = latest.Version + 1
+		}
+
+		data, err := json.Marshal(version)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the configuration version")
+		}
+
+		saved, appErr := p.API.KVSetWithOptions(configHistoryKey(version.Version), data, model.PluginKVSetOptions{Atomic: true})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to save the configuration version")
+		}
+		if !saved {
+			continue
+		}
+
+		if appErr := p.API.KVSet(configHistoryLatestKey, []byte(strconv.Itoa(version.Version))); appErr != nil {
+			return errors.Wrap(appErr, "failed to save the latest configuration version")
+		}
+		if version.Version > maxConfigHistory {
+			if appErr := p.API.KVDelete(configHistoryKey(version.Version - maxConfigHistory)); appErr != nil {
+				return errors.Wrap(appErr, "failed to delete an old configuration version")
+			}
+		}
+		return nil
+	}
+
+	return errors.New("failed to save the configuration version after concurrent updates")
+}
+
+// getLatestConfigVersion returns the latest version of the history, or nil if the history is empty.
+func (p *Plugin) getLatestConfigVersion() (*configVersion, error) {
+	value, appErr := p.API.KVGet(configHistoryLatestKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the latest configuration version")
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	latest, err := strconv.Atoi(string(value))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the latest configuration version")
+	}
+
+	return p.getConfigVersion(latest)
+}
+
+// getConfigVersion returns the version of the history, or nil if it doesn't exist.
+func (p *Plugin) getConfigVersion(version int) (*configVersion, error) {
+	value, appErr := p.API.KVGet(configHistoryKey(version))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the configuration version")
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	var v configVersion
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the configuration version")
+	}
+
+	return &v, nil
+}
+
+// executeHistoryCommand lists the latest versions of the configuration with their changes.
+func (p *Plugin) executeHistoryCommand(_ *model.CommandArgs, _ []string) string {
+	latest, err := p.getLatestConfigVersion()
+	if err != nil {
+		return fmt.Sprintf("Failed to get the configuration history: %s", err.Error())
+	}
+	if latest == nil {
+		return "No configuration change has been recorded yet."
+	}
+
+	text := "Latest configuration versions:"
+	for version := latest.Version; version > 0 && version > latest.Version-configHistoryListed; version-- {
+		v := latest
+		if version != latest.Version {
+			if v, err = p.getConfigVersion(version); err != nil {
+				return fmt.Sprintf("Failed to get the configuration history: %s", err.Error())
+			}
+			if v == nil {
+				break
+			}
+		}
+
+		text += fmt.Sprintf("\n\n**Version %d**, %s", v.Version, time.UnixMilli(v.Timestamp).UTC().Format(time.RFC1123))
+		if len(v.Changes) == 0 {
+			text += "\nInitial configuration."
+		} else {
+			text += "\n" + formatChanges(v.Changes)
+		}
+	}
+
+	return text + fmt.Sprintf("\n\nRun `/%s rollback <version>` to restore a version.", commandTrigger)
+}
+
+// executeRollbackCommand restores the configuration of a version of the history, keeping the
+// current secrets. The restored configuration is in turn recorded as a new version.
+func (p *Plugin) executeRollbackCommand(_ *model.CommandArgs, parameters []string) string {
+	if len(parameters) != 1 {
+		return fmt.Sprintf("Please provide the version to restore, e.g. `/%s rollback 3`. Run `/%s history` to list the versions.", commandTrigger, commandTrigger)
+	}
+	version, err := strconv.Atoi(parameters[0])
+	if err != nil {
+		return fmt.Sprintf("Invalid version `%s`.", parameters[0])
+	}
+
+	v, err := p.getConfigVersion(version)
+	if err != nil {
+		return fmt.Sprintf("Failed to get the configuration version: %s", err.Error())
+	}
+	if v == nil {
+		return fmt.Sprintf("Version %d doesn't exist.", version)
+	}
+	current := p.getConfiguration()
+	restored := v.Configuration
+	copySecrets(restored, current)
+	if err = validateConfiguration(restored); err != nil {
+		return fmt.Sprintf("Version %d can't be restored: %s", version, err.Error())
+	}
+
+	changes := diffConfigurations(current, restored)
+	if len(changes) == 0 {
+		return fmt.Sprintf("The current configuration is the same as version %d.", version)
+	}
+	if err = p.applyConfiguration(restored); err != nil {
+		return fmt.Sprintf("Failed to restore version %d: %s", version, err.Error())
+	}
+
+	return fmt.Sprintf("Version %d has been restored, with the changes:\n%s", version, formatChanges(changes))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestConfigHistory(t *testing.T) {
+	newPlugin := func() (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "http,https", "https", ""), &mockAPI{admins: map[string]bool{"admin": true}}, nil)
+	}
+	changeConfiguration := func(t *testing.T, p *Plugin, mockAPI *mockAPI, settings map[string]interface{}) {
+		mockAPI.savedConfig = settings
+		require.NoError(t, p.OnConfigurationChange())
+	}
+	command := func(t *testing.T, p *Plugin, text string) string {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: text, UserId: "admin"})
+		require.Nil(t, appErr)
+		return response.Text
+	}
+
+	t.Run("records each configuration change once", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "http,https"})
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "http,https"})
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": ""})
+
+		latest, err := p.getLatestConfigVersion()
+		require.NoError(t, err)
+		require.NotNil(t, latest)
+		assert.Equal(t, 2, latest.Version)
+		require.Len(t, latest.Changes, 1)
+		assert.Equal(t, "AllowedProtocolListLink", latest.Changes[0].Setting)
+		assert.Equal(t, "http,https", latest.Changes[0].Old)
+		assert.Equal(t, "", latest.Changes[0].New)
+	})
+
+	t.Run("doesn't record a version already recorded by another server", func(t *testing.T) {
+		p, _ := newPlugin()
+		now := time.Now()
+
+		require.NoError(t, p.recordConfiguration(&configuration{AllowedProtocolListLink: "https"}, now))
+		other := &Plugin{}
+		other.API = p.API
+		require.NoError(t, other.recordConfiguration(&configuration{AllowedProtocolListLink: "https"}, now))
+
+		latest, err := p.getLatestConfigVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 1, latest.Version)
+	})
+
+	t.Run("keeps a limited number of versions", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+
+		for i := 0; i <= maxConfigHistory; i++ {
+			require.NoError(t, p.recordConfiguration(&configuration{RateLimitLinks: i}, time.Now()))
+		}
+		assert.Nil(t, mockAPI.kv[configHistoryKey(1)])
+		assert.NotNil(t, mockAPI.kv[configHistoryKey(2)])
+		assert.NotNil(t, mockAPI.kv[configHistoryKey(maxConfigHistory+1)])
+	})
+
+	t.Run("doesn't record the secrets", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "https", "violationwebhooksecret": "secret1"})
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "https", "violationwebhooksecret": "secret2"})
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "http", "violationwebhooksecret": "secret3"})
+
+		latest, err := p.getLatestConfigVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 2, latest.Version, "changes of the secrets alone shouldn't be recorded")
+		require.Len(t, latest.Changes, 1)
+		assert.Equal(t, "AllowedProtocolListLink", latest.Changes[0].Setting)
+		for key, value := range mockAPI.kv {
+			assert.NotContains(t, string(value), "secret", key)
+		}
+
+		// The current secrets are kept on rollback
+		assert.Equal(t, "Version 1 has been restored, with the changes:\n* `AllowedProtocolListLink`: `\"http\"` → `\"https\"`", command(t, p, "/linkfilter rollback 1"))
+		assert.Equal(t, "secret3", mockAPI.savedConfig["violationwebhooksecret"])
+	})
+
+	t.Run("doesn't record invalid configurations", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "https"})
+		mockAPI.savedConfig = map[string]interface{}{"allowedprotocollistlink": "http", "schedules": "off_hours: someday"}
+		require.Error(t, p.OnConfigurationChange())
+
+		latest, err := p.getLatestConfigVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 1, latest.Version)
+		assert.Equal(t, "https", latest.Configuration.AllowedProtocolListLink)
+	})
+
+	t.Run("lists the history and rolls back a version", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+
+		assert.Equal(t, "No configuration change has been recorded yet.", command(t, p, "/linkfilter history"))
+
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": "http,https"})
+		changeConfiguration(t, p, mockAPI, map[string]interface{}{"allowedprotocollistlink": ""})
+
+		history := command(t, p, "/linkfilter history")
+		assert.Contains(t, history, "**Version 2**")
+		assert.Contains(t, history, "* `AllowedProtocolListLink`: `\"http,https\"` → `\"\"`")
+		assert.Contains(t, history, "**Version 1**")
+		assert.Contains(t, history, "Initial configuration.")
+
+		assert.Equal(t, "Version 3 doesn't exist.", command(t, p, "/linkfilter rollback 3"))
+		assert.Equal(t, "Version 1 has been restored, with the changes:\n* `AllowedProtocolListLink`: `\"\"` → `\"http,https\"`", command(t, p, "/linkfilter rollback 1"))
+		assert.Equal(t, "http,https", mockAPI.savedConfig["allowedprotocollistlink"])
+
+		// The server calls OnConfigurationChange after the configuration is saved
+		require.NoError(t, p.OnConfigurationChange())
+		assert.Equal(t, "http,https", p.getConfiguration().AllowedProtocolListLink)
+		latest, err := p.getLatestConfigVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 3, latest.Version)
+		assert.Equal(t, "The current configuration is the same as version 1.", command(t, p, "/linkfilter rollback 1"))
+	})
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"reflect"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -23,12 +24,84 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	RejectPlainLinks             bool
-	AllowedProtocolListLink      string
-	AllowedProtocolListPlainText string
-	CreatePostWarningMessage     string
-	EditPostWarningMessage       string
-	RewriteProtocolList          string
+	RejectPlainLinks                          bool
+	AllowedProtocolListLink                   string
+	AllowedProtocolListPlainText              string
+	CreatePostWarningMessage                  string
+	EditPostWarningMessage                    string
+	RewriteProtocolList                       string
+	ProtectedDomains                          string
+	HomoglyphAction                           string
+	CleanupLinks                              bool
+	StripQueryParameters                      string
+	RedirectWrappers                          string
+	SafeLinkMode                              string
+	SafeLinkTemplate                          string
+	SafeLinkExcludedHosts                     string
+	InterstitialSigningKey                    string
+	RateLimitLinks                            int
+	RateLimitWindowSeconds                    int
+	RateLimitPerChannel                       bool
+	RateLimitMessage                          string
+	NewUserAccountAgeHours                    int
+	NewUserMinPostCount                       int
+	NewUserAllowedProtocolListLink            string
+	NewUserAllowedProtocolListPlainText       string
+	NewUserRejectPlainLinks                   bool
+	MaxEmbeddedLinks                          int
+	MaxPlainLinks                             int
+	MaxDistinctHosts                          int
+	MaxEmbeddedLinkDensity                    int
+	MaxPlainLinkDensity                       int
+	MismatchedLinkTextAction                  string
+	ChannelFieldAction                        string
+	DirectMessagePolicy                       bool
+	DirectMessageAllowedProtocolListLink      string
+	DirectMessageAllowedProtocolListPlainText string
+	DirectMessagePrivacyMode                  bool
+	OffHoursAllowedProtocolListLink           string
+	OffHoursAllowedProtocolListPlainText      string
+	Schedules                                 string
+	SafetyGuard                               bool
+	SafetyGuardMaxRejectionRate               int
+	SafetyGuardMinPosts                       int
+	URLRules                                  string
+	URLRuleAction                             string
+	IPLinkAction                              string
+	BlockIPLinks                              bool
+	IPAllowList                               string
+	IPDenyList                                string
+	PrivateIPLinks                            string
+	InternalChannels                          string
+	AllowedPorts                              string
+	PortAction                                string
+	BlockedFileExtensions                     string
+	FileExtensionAction                       string
+	AllowUnsafeSchemes                        bool
+	ShortenerHosts                            string
+	ShortLinkHTTPResolver                     bool
+	ShortLinkResolverTimeout                  int
+	UnresolvedShortLinkAction                 string
+	ReputationAction                          string
+	DeniedHosts                               string
+	ReputationFeeds                           string
+	URLPatterns                               string
+	ReputationServiceURL                      string
+	CheckerTimeout                            int
+	CheckerCacheTTL                           int
+	CheckerFailMode                           string
+	ReputationServiceSecret                   string
+	ReputationServiceTimeout                  int
+	ReputationServiceFallback                 string
+	ViolationWebhookURL                       string
+	ViolationWebhookSecret                    string
+	EnableAuditLog                            bool
+	AuditLogFile                              string
+	AuditLogMaxSize                           int
+	AuditLogMaxBackups                        int
+
+	// derivedConfiguration holds the values computed from the settings above.
+	derivedConfiguration
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -88,33 +161,150 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	// The derived values are computed before the configuration becomes active, so the hooks
+	// never see the settings of a configuration with the derived values of another one.
+	if err := p.initConfiguration(configuration); err != nil {
+		return err
+	}
+
+	// The previous configuration is nil when the plugin starts
+	p.configurationLock.RLock()
+	previous := p.configuration
+	p.configurationLock.RUnlock()
 	p.setConfiguration(configuration)
+	p.startReputationFeeds(previous, configuration)
+
+	if err := p.recordConfiguration(configuration, time.Now()); err != nil {
+		p.API.LogError("Failed to record the configuration change", "error", err.Error())
+	}
+
+	if err := p.configureAuditFile(configuration); err != nil {
+		p.API.LogError("Failed to configure the audit file", "error", err.Error())
+	}
+	p.checkConfigurationSafety(previous, configuration)
+
+	return nil
+}
 
-	return p.initConfiguration(configuration)
+// derivedConfiguration holds the values computed from the settings of a configuration. It's
+// built by newDerivedConfiguration before the configuration becomes active, and never changed
+// afterwards, so it's swapped in with the settings it was computed from.
+type derivedConfiguration struct {
+	defaultPolicy           *protocolPolicy
+	newUserPolicy           *protocolPolicy
+	directMessagePolicy     *protocolPolicy
+	offHoursPolicy          *protocolPolicy
+	schedules               map[string][]*schedule
+	urlRules                []*urlRule
+	ipAllowList             []*net.IPNet
+	ipDenyList              []*net.IPNet
+	allowedPorts            map[string][]portRange
+	blockedFileExtensions   []string
+	shortenerHosts          []string
+	shortLinkResolvers      []shortLinkResolver
+	reputationCheckers      []URLChecker
+	rewriteProtocolList     []string
+	protectedDomains        []string
+	strippedQueryParameters []string
+	redirectWrappers        []redirectWrapper
+	safeLinkExcludedHosts   []string
+	safeLinkTemplateHost    string
 }
 
+// initConfiguration computes the derived values of the configuration. The configuration is left
+// unchanged if any of its settings is invalid.
 func (p *Plugin) initConfiguration(configuration *configuration) error {
-	// Addind space around the words (Link)
-	regexStringLink := wordListToRegex(configuration.AllowedProtocolListLink)
-	regexLink, err := regexp.Compile(regexStringLink)
+	derived, err := newDerivedConfiguration(configuration)
 	if err != nil {
 		return err
 	}
-	p.allowedProtocolsRegexLink = regexLink
+	configuration.derivedConfiguration = *derived
 
-	// Addind space around the words (Plain Text)
-	regexStringPlainText := wordListToRegex(configuration.AllowedProtocolListPlainText)
-	regexPlainText, err := regexp.Compile(regexStringPlainText)
-	if err != nil {
-		return err
+	return nil
+}
+
+// newDerivedConfiguration parses the settings of the configuration, and returns the values
+// computed from them. It returns an error if any of the settings is invalid.
+func newDerivedConfiguration(configuration *configuration) (*derivedConfiguration, error) {
+	var d derivedConfiguration
+	var err error
+
+	if d.defaultPolicy, err = newProtocolPolicy(policyDefault, configuration.AllowedProtocolListLink, configuration.AllowedProtocolListPlainText, configuration.RejectPlainLinks); err != nil {
+		return nil, err
+	}
+	if d.newUserPolicy, err = newProtocolPolicy(policyNewUser, configuration.NewUserAllowedProtocolListLink, configuration.NewUserAllowedProtocolListPlainText, configuration.RejectPlainLinks || configuration.NewUserRejectPlainLinks); err != nil {
+		return nil, err
+	}
+	if d.directMessagePolicy, err = newProtocolPolicy(policyDirectMessage, configuration.DirectMessageAllowedProtocolListLink, configuration.DirectMessageAllowedProtocolListPlainText, configuration.RejectPlainLinks); err != nil {
+		return nil, err
+	}
+	if d.offHoursPolicy, err = newProtocolPolicy(policyOffHours, configuration.OffHoursAllowedProtocolListLink, configuration.OffHoursAllowedProtocolListPlainText, configuration.RejectPlainLinks); err != nil {
+		return nil, err
+	}
+
+	if d.urlRules, err = parseURLRules(configuration.URLRules); err != nil {
+		return nil, err
+	}
+	if d.ipAllowList, err = parseCIDRList(configuration.IPAllowList); err != nil {
+		return nil, err
+	}
+	if d.ipDenyList, err = parseCIDRList(configuration.IPDenyList); err != nil {
+		return nil, err
+	}
+	if d.allowedPorts, err = parseAllowedPorts(configuration.AllowedPorts); err != nil {
+		return nil, err
+	}
+	d.blockedFileExtensions = parseFileExtensions(configuration.BlockedFileExtensions)
+
+	if d.reputationCheckers, err = newReputationCheckers(configuration); err != nil {
+		return nil, err
+	}
+
+	d.shortenerHosts = parseShortenerHosts(configuration.ShortenerHosts)
+	if configuration.ShortLinkHTTPResolver {
+		d.shortLinkResolvers = append(d.shortLinkResolvers, newHTTPShortLinkResolver(time.Duration(configuration.ShortLinkResolverTimeout)*time.Millisecond))
+	}
+
+	if d.schedules, err = parseSchedules(configuration.Schedules); err != nil {
+		return nil, err
 	}
-	p.allowedProtocolsRegexPlainText = regexPlainText
 
 	for _, scheme := range strings.Split(configuration.RewriteProtocolList, ",") {
-		p.rewriteProtocolList = append(p.rewriteProtocolList, strings.TrimSpace(scheme))
+		d.rewriteProtocolList = append(d.rewriteProtocolList, strings.TrimSpace(scheme))
 	}
 
-	return nil
+	for _, domain := range util.TrimString(strings.Split(configuration.ProtectedDomains, ",")) {
+		host, _, _ := canonicalizeHost(domain)
+		d.protectedDomains = append(d.protectedDomains, host)
+	}
+
+	d.strippedQueryParameters = append([]string{}, defaultStrippedQueryParameters...)
+	for _, parameter := range util.TrimString(strings.Split(configuration.StripQueryParameters, ",")) {
+		d.strippedQueryParameters = append(d.strippedQueryParameters, strings.ToLower(parameter))
+	}
+	d.redirectWrappers = append(
+		parseRedirectWrappers(defaultRedirectWrappers),
+		parseRedirectWrappers(strings.Split(configuration.RedirectWrappers, ","))...,
+	)
+
+	for _, host := range util.TrimString(strings.Split(configuration.SafeLinkExcludedHosts, ",")) {
+		canonicalHost, _, _ := canonicalizeHost(host)
+		d.safeLinkExcludedHosts = append(d.safeLinkExcludedHosts, canonicalHost)
+	}
+	if configuration.SafeLinkMode == safeLinkModeTemplate {
+		// Links to the safe-link service itself are never rewritten, so edits don't wrap them twice
+		templateHost, err := parseSafeLinkTemplate(configuration.SafeLinkTemplate)
+		if err != nil {
+			return nil, err
+		}
+		d.safeLinkExcludedHosts = append(d.safeLinkExcludedHosts, templateHost)
+		d.safeLinkTemplateHost = templateHost
+	}
+	if configuration.SafeLinkMode == safeLinkModeInterstitial && configuration.InterstitialSigningKey == "" {
+		return nil, errors.New("the interstitial signing key must be generated to use the interstitial page")
+	}
+
+	return &d, nil
 }
 
 func wordListToRegex(wordList string) (regexStr string) {
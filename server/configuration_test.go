@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWordListToRegex(t *testing.T) {
@@ -26,3 +27,38 @@ func TestWordListToRegex(t *testing.T) {
 		assert.Equal(t, regexStr, `(?mi)\b(https|http|mailto)\b`)
 	})
 }
+
+func TestOnConfigurationChange(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	mockAPI := &mockAPI{}
+	p.API = mockAPI
+
+	mockAPI.savedConfig = map[string]interface{}{"rewriteprotocollist": "tel", "urlrules": "deny evil.com"}
+	require.NoError(t, p.OnConfigurationChange())
+	require.NoError(t, p.OnConfigurationChange())
+	assert.Equal(t, []string{"tel"}, p.getConfiguration().rewriteProtocolList, "the derived values shouldn't accumulate")
+
+	mockAPI.savedConfig = map[string]interface{}{"rewriteprotocollist": "sms", "urlrules": "deny other.com", "schedules": "off_hours: someday"}
+	assert.Error(t, p.OnConfigurationChange())
+
+	configuration := p.getConfiguration()
+	assert.Equal(t, "deny evil.com", configuration.URLRules, "an invalid configuration shouldn't become active")
+	require.Len(t, configuration.urlRules, 1)
+	assert.Equal(t, "deny evil.com", configuration.urlRules[0].text)
+	assert.Equal(t, []string{"tel"}, configuration.rewriteProtocolList)
+}
+
+func TestNewDerivedConfiguration(t *testing.T) {
+	derived, err := newDerivedConfiguration(&configuration{
+		AllowedProtocolListLink: "http,https",
+		URLRules:                "allow github.com/our-org/*",
+		ProtectedDomains:        "Example.COM",
+	})
+	require.NoError(t, err)
+	assert.True(t, derived.defaultPolicy.allowsLink("https"))
+	assert.Len(t, derived.urlRules, 1)
+	assert.Equal(t, []string{"example.com"}, derived.protectedDomains)
+
+	_, err = newDerivedConfiguration(&configuration{SafeLinkMode: safeLinkModeInterstitial})
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// dangerousSchemes are the schemes which can run code or embed content in the browser. They're
+// always rejected, even if they're allowed by the allowed protocols lists, unless unsafe schemes
+// are allowed explicitly.
+var dangerousSchemes = []string{"data", "javascript", "vbscript", "blob"}
+
+// linkDestinationRegex matches the start of the destination of embedded links, including the
+// destinations the link regular expressions don't match, e.g. `[text](java script:...)`.
+var linkDestinationRegex = regexp.MustCompile(`\]\(([^)\n]*)`)
+
+// isDangerousScheme returns whether the scheme is one of the dangerous schemes, in any case.
+func isDangerousScheme(scheme string) bool {
+	return slices.Contains(dangerousSchemes, strings.ToLower(scheme))
+}
+
+// normalizeSchemeText decodes the HTML entities of the text, and removes the whitespace, control
+// and invisible characters browsers ignore in schemes, e.g. `Java&#x09;Script` becomes `javascript`.
+func normalizeSchemeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, html.UnescapeString(text))
+}
+
+// dangerousSchemePrefix returns the dangerous scheme the text starts with once normalized, or an
+// empty string.
+func dangerousSchemePrefix(text string) string {
+	normalized := strings.TrimLeftFunc(normalizeSchemeText(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, scheme := range dangerousSchemes {
+		if strings.HasPrefix(normalized, scheme+":") && len(normalized) > len(scheme)+1 {
+			return scheme
+		}
+	}
+
+	return ""
+}
+
+// findObfuscatedDangerousSchemes returns the dangerous schemes hidden in the message with HTML
+// entities, whitespace, control characters or mixed case, in the destinations of embedded links,
+// and in plain text words if includePlainText is set.
+func findObfuscatedDangerousSchemes(message string, includePlainText bool) []string {
+	var schemes []string
+	add := func(scheme string) {
+		if scheme != "" && !slices.Contains(schemes, scheme) {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	for _, match := range linkDestinationRegex.FindAllStringSubmatch(message, -1) {
+		add(dangerousSchemePrefix(match[1]))
+	}
+	if includePlainText {
+		for _, word := range strings.Fields(message) {
+			add(dangerousSchemePrefix(word))
+		}
+	}
+
+	return schemes
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestDangerousSchemePrefix(t *testing.T) {
+	var tests = []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{name: "safe scheme", text: "https://example.com"},
+		{name: "scheme alone", text: "data:"},
+		{name: "word", text: "javascript"},
+		{name: "dangerous scheme", text: "javascript:alert(1)", expected: "javascript"},
+		{name: "mixed case", text: "JaVaScRiPt:alert(1)", expected: "javascript"},
+		{name: "whitespace", text: " java script:alert(1)", expected: "javascript"},
+		{name: "embedded tab", text: "vb\tscript:msgbox(1)", expected: "vbscript"},
+		{name: "decimal entity", text: "jav&#97;script:alert(1)", expected: "javascript"},
+		{name: "hexadecimal entity", text: "&#x64;ata:text/html,x", expected: "data"},
+		{name: "entity colon", text: "blob&colon;https://example.com/id", expected: "blob"},
+		{name: "tab entity", text: "java&#x09;script:alert(1)", expected: "javascript"},
+		{name: "zero width space", text: "java\u200bscript:alert(1)", expected: "javascript"},
+		{name: "leading punctuation", text: "<javascript:alert(1)>", expected: "javascript"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, dangerousSchemePrefix(test.text))
+		})
+	}
+}
+
+func TestGetInvalidProtocolsDangerousSchemes(t *testing.T) {
+	var tests = []struct {
+		name               string
+		message            string
+		rejectPlainLinks   bool
+		allowUnsafeSchemes bool
+		expected           []string
+	}{
+		{name: "allowed scheme", message: "[site](https://example.com)", expected: []string{}},
+		{name: "allowed dangerous scheme", message: "[x](javascript:alert(1))", expected: []string{"javascript"}},
+		{name: "mixed case", message: "[x](JavaScript:alert(1))", expected: []string{"javascript"}},
+		{name: "entity", message: "[x](jav&#x61;script:alert(1))", expected: []string{"javascript"}},
+		{name: "entity colon", message: "[x](javascript&#58;alert(1))", expected: []string{"javascript"}},
+		{name: "whitespace", message: "[x](java script:alert(1))", rejectPlainLinks: true, expected: []string{"script", "javascript"}},
+		{name: "embedded tab", message: "[x](data\t:text/html;base64,PHNjcmlwdD4=)", expected: []string{"data"}},
+		{name: "plain text not filtered", message: "data:text/html,x", expected: []string{}},
+		{name: "plain text", message: "data:text/html,x", rejectPlainLinks: true, expected: []string{"data"}},
+		{name: "obfuscated plain text", message: "see v&#98;script:msgbox(1)", rejectPlainLinks: true, expected: []string{"script", "vbscript"}},
+		{name: "plain text word", message: "here is the data: it's fine", rejectPlainLinks: true, expected: []string{}},
+		{name: "unsafe schemes allowed", message: "[x](javascript:alert(1)) [y](jav&#x61;script:alert(1))", allowUnsafeSchemes: true, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPlugin(t, test.rejectPlainLinks, "https,javascript,data,vbscript,blob", "https,javascript,data,vbscript,blob", "")
+			p.configuration.AllowUnsafeSchemes = test.allowUnsafeSchemes
+			require.NoError(t, p.initConfiguration(p.configuration))
+
+			post := &model.Post{Message: test.message}
+			invalidProtocols := p.getInvalidProtocols(p.extractURLs(post), post, time.Now())
+			assert.ElementsMatch(t, test.expected, invalidProtocols)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// channelInfoCacheTTL is how long the channel information is cached. Channel types never change,
+// but channel names can be.
+const channelInfoCacheTTL = 5 * time.Minute
+
+// channelInfo is what the policies need to know about a channel.
+type channelInfo struct {
+	channelType string
+	name        string
+	teamID      string
+	expiresAt   time.Time
+}
+
+// getChannelInfo returns what is known about the channel, looking the channel up if it isn't cached.
+func (p *Plugin) getChannelInfo(channelID string) (*channelInfo, error) {
+	p.channelInfoLock.Lock()
+	cached, ok := p.channelInfoCache[channelID]
+	p.channelInfoLock.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached, nil
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the channel")
+	}
+	info := &channelInfo{
+		channelType: channel.Type,
+		name:        channel.Name,
+		teamID:      channel.TeamId,
+		expiresAt:   time.Now().Add(channelInfoCacheTTL),
+	}
+
+	p.channelInfoLock.Lock()
+	if p.channelInfoCache == nil {
+		p.channelInfoCache = make(map[string]*channelInfo)
+	}
+	p.channelInfoCache[channelID] = info
+	p.channelInfoLock.Unlock()
+
+	return info, nil
+}
+
+// isDirectMessage returns whether the post is made in a direct or group message channel.
+func (p *Plugin) isDirectMessage(post *model.Post) bool {
+	if post == nil || post.ChannelId == "" {
+		return false
+	}
+
+	info, err := p.getChannelInfo(post.ChannelId)
+	if err != nil {
+		p.API.LogWarn("Failed to get the channel type", "channel_id", post.ChannelId, "error", err.Error())
+		return false
+	}
+
+	return isDirectMessageChannel(info)
+}
+
+// isDirectMessageChannel returns whether the channel is a direct or group message channel.
+func isDirectMessageChannel(info *channelInfo) bool {
+	return info.channelType == model.CHANNEL_DIRECT || info.channelType == model.CHANNEL_GROUP
+}
+
+// isPrivacyModeApplied returns whether the records of the decisions about the post must not
+// contain any content of the message. The privacy mode applies when the channel type can't be
+// confirmed.
+func (p *Plugin) isPrivacyModeApplied(post *model.Post) bool {
+	if !p.getConfiguration().DirectMessagePrivacyMode {
+		return false
+	}
+	if post == nil || post.ChannelId == "" {
+		return true
+	}
+
+	info, err := p.getChannelInfo(post.ChannelId)
+	if err != nil {
+		p.API.LogWarn("Failed to get the channel type", "channel_id", post.ChannelId, "error", err.Error())
+		return true
+	}
+
+	return isDirectMessageChannel(info)
+}
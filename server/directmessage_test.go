@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestDirectMessagePolicy(t *testing.T) {
+	newPlugin := func(policy, privacyMode bool) (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "https", "", ""), &mockAPI{channels: map[string]*model.Channel{
+			"public": {Id: "public", Type: model.CHANNEL_OPEN},
+			"direct": {Id: "direct", Type: model.CHANNEL_DIRECT},
+			"group":  {Id: "group", Type: model.CHANNEL_GROUP},
+		}}, func(c *configuration) {
+			c.DirectMessagePolicy = policy
+			c.DirectMessageAllowedProtocolListLink = "http,https,ftp"
+			c.DirectMessageAllowedProtocolListPlainText = "http,https"
+			c.DirectMessagePrivacyMode = privacyMode
+		})
+	}
+	filter := func(p *Plugin, channelID, message string) string {
+		_, errMessage := p.MessageWillBePosted(nil, &model.Post{UserId: "user1", ChannelId: channelID, Message: message})
+		return errMessage
+	}
+
+	t.Run("applies the direct message policy to direct and group messages", func(t *testing.T) {
+		p, _ := newPlugin(true, false)
+
+		assert.Equal(t, "Schemes not allowed: ftp", filter(p, "public", "[file](ftp://example.com/file)"))
+		assert.Equal(t, "Schemes not allowed: https", filter(p, "public", "https://example.com"))
+
+		for _, channelID := range []string{"direct", "group"} {
+			assert.Empty(t, filter(p, channelID, "[file](ftp://example.com/file)"))
+			assert.Empty(t, filter(p, channelID, "https://example.com"))
+			assert.Equal(t, "Schemes not allowed: ftp", filter(p, channelID, "ftp://example.com/file"))
+		}
+	})
+
+	t.Run("applies the default policy when the direct message policy is disabled", func(t *testing.T) {
+		p, _ := newPlugin(false, false)
+
+		assert.Equal(t, "Schemes not allowed: ftp", filter(p, "direct", "[file](ftp://example.com/file)"))
+	})
+
+	t.Run("logs only the scheme and host of direct message links in privacy mode", func(t *testing.T) {
+		p, mockAPI := newPlugin(false, true)
+
+		assert.NotEmpty(t, filter(p, "direct", "secret [file](ftp://user@example.com/private/report.pdf?token=abc)"))
+		require.Len(t, mockAPI.infoLogs, 1)
+		log := mockAPI.infoLogs[0]
+		assert.Equal(t, "Post rejected by the link filter", log.message)
+		assert.Equal(t, []interface{}{"user_id", "user1", "channel_id", "direct", "links", "ftp://example.com"}, log.keyValuePairs)
+	})
+
+	t.Run("logs the reasons and URLs outside of direct messages", func(t *testing.T) {
+		p, mockAPI := newPlugin(false, true)
+
+		assert.NotEmpty(t, filter(p, "public", "[file](ftp://example.com/report.pdf)"))
+		require.Len(t, mockAPI.infoLogs, 1)
+		assert.Equal(t, []interface{}{
+			"user_id", "user1",
+			"channel_id", "public",
+			"reasons", "Schemes not allowed: ftp",
+			"urls", "ftp://example.com/report.pdf",
+		}, mockAPI.infoLogs[0].keyValuePairs)
+	})
+
+	t.Run("caches the channel types", func(t *testing.T) {
+		p, mockAPI := newPlugin(true, false)
+
+		assert.Empty(t, filter(p, "direct", "[file](ftp://example.com/file)"))
+		delete(mockAPI.channels, "direct")
+		assert.Empty(t, filter(p, "direct", "[file](ftp://example.com/file)"))
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const checkFileExtensions = "file_extensions"
+
+// decoyExtensionRegex matches the extension shown before the real extension of a file name with a
+// double extension, e.g. pdf in invoice.pdf.exe.
+var decoyExtensionRegex = regexp.MustCompile(`^[a-z0-9]{1,5}$`)
+
+// parseFileExtensions parses a comma separated list of file extensions, with or without the
+// leading dot.
+func parseFileExtensions(list string) []string {
+	var extensions []string
+	for _, extension := range util.TrimString(strings.Split(list, ",")) {
+		extensions = append(extensions, strings.ToLower(strings.TrimPrefix(extension, ".")))
+	}
+	return extensions
+}
+
+// fileName returns the file name at the end of a decoded path, without the path parameters and the
+// trailing dots and spaces ignored by Windows, e.g. setup.exe for /download/setup.exe;v=1. A query
+// or fragment decoded from the path, e.g. %3F in setup.exe%3Fv=1, is removed as well.
+func fileName(p string) string {
+	name := path.Base(p)
+	if i := strings.IndexAny(name, ";?#"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(strings.TrimRight(name, ". "))
+}
+
+// blockedExtension returns the blocked extension of the file name, and the decoy extension shown
+// before it in a double extension, e.g. exe and pdf for invoice.pdf.exe.
+func (p *Plugin) blockedExtension(name string) (extension, decoy string) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return "", ""
+	}
+
+	extension = parts[len(parts)-1]
+	if !slices.Contains(p.getConfiguration().blockedFileExtensions, extension) {
+		return "", ""
+	}
+	if len(parts) >= 3 && decoyExtensionRegex.MatchString(parts[len(parts)-2]) {
+		decoy = parts[len(parts)-2]
+	}
+
+	return extension, decoy
+}
+
+// checkFileExtensions flags the links pointing directly at files with a blocked extension, either
+// in the path or in a query parameter, e.g. download?file=setup.exe.
+func (p *Plugin) checkFileExtensions(u *detectedURL, _ *model.Post) *violation {
+	if u.canonical.host == "" {
+		return nil
+	}
+
+	names := []string{fileName(u.canonical.path)}
+	if query, err := url.ParseQuery(u.canonical.rawQuery); err == nil {
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range query[key] {
+				names = append(names, fileName(value))
+			}
+		}
+	}
+
+	for _, name := range names {
+		extension, decoy := p.blockedExtension(name)
+		if extension == "" {
+			continue
+		}
+
+		reason := fmt.Sprintf("The link to `%s` points to a `.%s` file.", u.canonical.String(), extension)
+		if decoy != "" {
+			reason = fmt.Sprintf("The link to `%s` points to a `.%s` file disguised as a `.%s` file.", u.canonical.String(), extension, decoy)
+		}
+		return &violation{
+			url:    u,
+			check:  checkFileExtensions,
+			action: p.getConfiguration().FileExtensionAction,
+			reason: reason,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCheckFileExtensions(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https,ftp", "", "")
+	p.configuration.FileExtensionAction = actionReject
+	p.configuration.BlockedFileExtensions = "exe, .MSI, apk, scr"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	var tests = []struct {
+		name           string
+		url            string
+		expectedReason string
+	}{
+		{name: "page", url: "https://example.com/downloads"},
+		{name: "allowed extension", url: "https://example.com/report.pdf"},
+		{name: "extension in a directory name", url: "https://example.com/setup.exe/readme.txt"},
+		{name: "blocked extension", url: "https://example.com/setup.exe", expectedReason: "The link to `https://example.com/setup.exe` points to a `.exe` file."},
+		{name: "upper case extension", url: "https://example.com/SETUP.MSI", expectedReason: "The link to `https://example.com/SETUP.MSI` points to a `.msi` file."},
+		{name: "extension before the query", url: "https://example.com/app.apk?download=1", expectedReason: "The link to `https://example.com/app.apk?download=1` points to a `.apk` file."},
+		{name: "extension in a query parameter", url: "https://example.com/download?file=files/setup.exe", expectedReason: "The link to `https://example.com/download?file=files/setup.exe` points to a `.exe` file."},
+		{name: "encoded extension", url: "https://example.com/setup%2Eexe", expectedReason: "The link to `https://example.com/setup.exe` points to a `.exe` file."},
+		{name: "trailing dot", url: "ftp://example.com/setup.exe.", expectedReason: "The link to `ftp://example.com/setup.exe.` points to a `.exe` file."},
+		{name: "path parameter", url: "https://example.com/setup.exe;jsessionid=1", expectedReason: "The link to `https://example.com/setup.exe;jsessionid=1` points to a `.exe` file."},
+		{name: "encoded query", url: "https://example.com/evil.exe%3Fx=1", expectedReason: "The link to `https://example.com/evil.exe?x=1` points to a `.exe` file."},
+		{name: "encoded fragment", url: "https://example.com/evil.exe%23x", expectedReason: "The link to `https://example.com/evil.exe#x` points to a `.exe` file."},
+		{name: "double extension", url: "https://example.com/invoice.pdf.scr", expectedReason: "The link to `https://example.com/invoice.pdf.scr` points to a `.scr` file disguised as a `.pdf` file."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[file](" + test.url + ")"}, time.Now())
+			if test.expectedReason == "" {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Equal(t, []string{test.expectedReason}, reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const checkHomoglyph = "homoglyph"
+
+// confusables maps characters to the Latin letters they are commonly mistaken for. It covers the
+// Cyrillic, Greek and Latin lookalikes used in phishing domains, it isn't a full implementation
+// of the Unicode confusables table.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'в': "b", 'с': "c", 'ԁ': "d", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i",
+	'ј': "j", 'к': "k", 'ӏ': "l", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'ԛ': "q", 'г': "r",
+	'ѕ': "s", 'т': "t", 'ц': "u", 'ѵ': "v", 'ԝ': "w", 'х': "x", 'у': "y", 'з': "3",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p",
+	'τ': "t", 'υ': "u", 'χ': "x", 'γ': "y", 'ω': "w",
+	// Latin lookalikes
+	'ı': "i", 'ɑ': "a", 'ɡ': "g", 'ℓ': "l", 'ò': "o", 'ó': "o", 'ö': "o", 'à': "a", 'á': "a",
+	'é': "e", 'è': "e", 'í': "i", 'ü': "u", 'ú': "u",
+	// Digits
+	'0': "o", '1': "l",
+}
+
+// confusableScripts are the scripts whose letters are commonly mixed in a single label to imitate
+// a Latin domain.
+var confusableScripts = map[string]*unicode.RangeTable{
+	"Latin":    unicode.Latin,
+	"Cyrillic": unicode.Cyrillic,
+	"Greek":    unicode.Greek,
+	"Armenian": unicode.Armenian,
+}
+
+// checkHomoglyphs flags hosts that look like one of the protected domains without being that
+// domain, and hosts with labels mixing letters from different scripts.
+func (p *Plugin) checkHomoglyphs(u *detectedURL, _ *model.Post) *violation {
+	configuration := p.getConfiguration()
+	host := u.canonical.unicodeHost
+	if host == "" || u.canonical.isIP {
+		return nil
+	}
+
+	protectedDomains := p.getConfiguration().protectedDomains
+	for _, protected := range protectedDomains {
+		if u.canonical.host == protected || strings.HasSuffix(u.canonical.host, "."+protected) {
+			return nil
+		}
+	}
+
+	skeleton := homoglyphSkeleton(host)
+	for _, protected := range protectedDomains {
+		protectedSkeleton := homoglyphSkeleton(protected)
+		if skeleton == protectedSkeleton || strings.HasSuffix(skeleton, "."+protectedSkeleton) {
+			return &violation{
+				url:    u,
+				check:  checkHomoglyph,
+				action: configuration.HomoglyphAction,
+				reason: fmt.Sprintf("The link `%s` points to `%s`, which looks like `%s` but is a different domain.", u.canonical, host, protected),
+			}
+		}
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if scripts := labelScripts(label); len(scripts) > 1 {
+			return &violation{
+				url:    u,
+				check:  checkHomoglyph,
+				action: configuration.HomoglyphAction,
+				reason: fmt.Sprintf("The link `%s` points to `%s`, which mixes characters from different scripts (%s).", u.canonical, host, strings.Join(scripts, ", ")),
+			}
+		}
+	}
+
+	return nil
+}
+
+// homoglyphSkeleton maps each character of the host to the Latin letter it looks like, so two
+// hosts which look the same have the same skeleton.
+func homoglyphSkeleton(host string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(host) {
+		if replacement, ok := confusables[r]; ok {
+			builder.WriteString(replacement)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+
+	skeleton := builder.String()
+	skeleton = strings.ReplaceAll(skeleton, "rn", "m")
+	skeleton = strings.ReplaceAll(skeleton, "vv", "w")
+	return skeleton
+}
+
+// labelScripts returns the sorted names of the confusable scripts used by the letters of a label.
+func labelScripts(label string) []string {
+	found := make(map[string]struct{})
+	for _, r := range label {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for name, table := range confusableScripts {
+			if unicode.Is(table, r) {
+				found[name] = struct{}{}
+			}
+		}
+	}
+
+	scripts := make([]string, 0, len(found))
+	for name := range found {
+		scripts = append(scripts, name)
+	}
+	sort.Strings(scripts)
+	return scripts
+}
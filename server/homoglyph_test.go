@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCheckHomoglyphs(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.ProtectedDomains = "paypal.com, GitHub.com"
+	p.configuration.HomoglyphAction = actionReject
+	require.NoError(t, p.initConfiguration(p.configuration))
+
+	var tests = []struct {
+		name           string
+		message        string
+		expectedReason string
+	}{
+		{
+			name:    "allows protected domain",
+			message: "https://github.com/mattermost",
+		},
+		{
+			name:    "allows subdomain of protected domain",
+			message: "[docs](https://docs.github.com)",
+		},
+		{
+			name:    "allows unrelated domain",
+			message: "https://example.org",
+		},
+		{
+			name:           "flags cyrillic lookalike",
+			message:        "https://раураl.com/login",
+			expectedReason: "looks like `paypal.com`",
+		},
+		{
+			name:           "flags punycode lookalike",
+			message:        "[github](https://xn--gthub-n2e.com)",
+			expectedReason: "looks like `github.com`",
+		},
+		{
+			name:           "flags digit lookalike",
+			message:        "https://paypa1.com",
+			expectedReason: "looks like `paypal.com`",
+		},
+		{
+			name:           "flags lookalike subdomain",
+			message:        "https://login.gіthub.com",
+			expectedReason: "looks like `github.com`",
+		},
+		{
+			name:           "flags mixed scripts",
+			message:        "https://exаmple.org",
+			expectedReason: "mixes characters from different scripts (Cyrillic, Latin)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detectedURLs := p.extractURLs(&model.Post{Message: test.message})
+			p.canonicalizeURLs(detectedURLs)
+			require.Len(t, detectedURLs, 1)
+
+			v := p.checkHomoglyphs(detectedURLs[0], nil)
+			if test.expectedReason == "" {
+				assert.Nil(t, v)
+				return
+			}
+			require.NotNil(t, v)
+			assert.Equal(t, actionReject, v.action)
+			assert.Contains(t, v.reason, test.expectedReason)
+		})
+	}
+}
+
+func TestHomoglyphActions(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.ProtectedDomains = "paypal.com"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	mockAPI := &mockAPI{}
+	p.API = mockAPI
+
+	var tests = []struct {
+		name             string
+		action           string
+		message          string
+		expectedError    string
+		expectedMessage  string
+		expectEphemeral  bool
+		ephemeralMessage string
+	}{
+		{
+			name:            "off allows the post",
+			action:          actionOff,
+			message:         "Login at https://раураl.com",
+			expectedMessage: "Login at https://раураl.com",
+		},
+		{
+			name:             "reject rejects the post",
+			action:           actionReject,
+			message:          "Login at https://раураl.com",
+			expectedError:    "Links not allowed: The link `https://xn--l-7sba6dbr.com` points to `раураl.com`, which looks like `paypal.com` but is a different domain.",
+			expectEphemeral:  true,
+			ephemeralMessage: "Your post has been rejected by the Link Filter.",
+		},
+		{
+			name:             "warn allows the post and warns the user",
+			action:           actionWarn,
+			message:          "Login at https://раураl.com",
+			expectedMessage:  "Login at https://раураl.com",
+			expectEphemeral:  true,
+			ephemeralMessage: CheckWarningMessage,
+		},
+		{
+			name:             "defang rewrites plain links",
+			action:           actionDefang,
+			message:          "Login at https://раураl.com/login now",
+			expectedMessage:  "Login at https(раураl.com/login) now",
+			expectEphemeral:  true,
+			ephemeralMessage: CheckWarningMessage,
+		},
+		{
+			name:             "defang rewrites embedded links",
+			action:           actionDefang,
+			message:          "Login at [PayPal](https://раураl.com) now",
+			expectedMessage:  "Login at PayPal https(раураl.com) now",
+			expectEphemeral:  true,
+			ephemeralMessage: CheckWarningMessage,
+		},
+		{
+			name:             "defang rewrites the plain link shown earlier as link text",
+			action:           actionDefang,
+			message:          "[see https://раураl.com/login](https://docs.example.com) https://раураl.com/login",
+			expectedMessage:  "[see https(раураl.com/login)](https://docs.example.com) https(раураl.com/login)",
+			expectEphemeral:  true,
+			ephemeralMessage: CheckWarningMessage,
+		},
+		{
+			name:             "defang rewrites embedded links showing their own URL",
+			action:           actionDefang,
+			message:          "[https://раураl.com](https://раураl.com) now",
+			expectedMessage:  "https(раураl.com) https(раураl.com) now",
+			expectEphemeral:  true,
+			ephemeralMessage: CheckWarningMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockAPI.sentEphemeralPost = nil
+			p.configuration.HomoglyphAction = test.action
+
+			post := &model.Post{Message: test.message, UserId: "user1", ChannelId: "channel1"}
+			detectedURLs := p.extractURLs(post)
+			p.canonicalizeURLs(detectedURLs)
+			errMessage := p.FilterPost(detectedURLs, post, false)
+
+			assert.Equal(t, test.expectedError, errMessage)
+			if test.expectedError == "" {
+				assert.Equal(t, test.expectedMessage, post.Message)
+			}
+			if test.expectEphemeral {
+				require.NotNil(t, mockAPI.sentEphemeralPost)
+				assert.Contains(t, mockAPI.sentEphemeralPost.Message, test.ephemeralMessage)
+			} else {
+				assert.Nil(t, mockAPI.sentEphemeralPost)
+			}
+		})
+	}
+}
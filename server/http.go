@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// ServeHTTP handles the HTTP requests sent to the plugin at /plugins/<plugin id>.
+func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case interstitialPath:
+		p.handleInterstitial(w, r)
+	case policyAPIPath:
+		p.handlePolicy(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	safeLinkModeInterstitial = "interstitial"
+
+	// interstitialPath is the path of the interstitial page, relative to the plugin URL.
+	interstitialPath = "/go"
+)
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>You are leaving Mattermost</title>
+<style>
+body { font-family: "Open Sans", sans-serif; max-width: 640px; margin: 64px auto; padding: 0 16px; color: #3d3c40; }
+.destination { font-family: monospace; word-break: break-all; background: #f4f4f4; border: 1px solid #ddd; padding: 12px; }
+.host { font-weight: bold; }
+a.button { display: inline-block; margin-top: 24px; padding: 10px 20px; background: #166de0; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<h2>You are leaving Mattermost</h2>
+<p>This link points to an external site on <span class="host">{{.Host}}</span>. Make sure you trust the destination before you continue.</p>
+<p class="destination">{{.URL}}</p>
+<a class="button" href="{{.URL}}" rel="noopener noreferrer">Continue to {{.Host}}</a>
+</body>
+</html>
+`))
+
+// signURL returns the signature of the URL, so the interstitial page only redirects to URLs
+// rewritten by the plugin and can't be abused as an open redirect.
+func signURL(key, rawURL string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(rawURL))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// interstitialURL returns the URL of the interstitial page served by the plugin.
+func (p *Plugin) interstitialURL() string {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+
+	return siteURL + "/plugins/" + manifest.ID + interstitialPath
+}
+
+// interstitialSafeLink rewrites the URL to go through the interstitial page. Links already
+// pointing to the interstitial page are returned as is.
+func (p *Plugin) interstitialSafeLink(rawURL string) string {
+	base := p.interstitialURL()
+	if strings.HasPrefix(rawURL, base+"?") {
+		return rawURL
+	}
+
+	query := url.Values{}
+	query.Set("u", rawURL)
+	query.Set("sig", signURL(p.getConfiguration().InterstitialSigningKey, rawURL))
+	return base + "?" + query.Encode()
+}
+
+// handleInterstitial shows the destination of a signed link and a warning, and lets the user
+// continue to the destination.
+func (p *Plugin) handleInterstitial(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rawURL := r.URL.Query().Get("u")
+	signature := r.URL.Query().Get("sig")
+	key := p.getConfiguration().InterstitialSigningKey
+	if rawURL == "" || key == "" || !hmac.Equal([]byte(signature), []byte(signURL(key, rawURL))) {
+		http.Error(w, "Invalid link", http.StatusForbidden)
+		return
+	}
+
+	destination, err := url.Parse(rawURL)
+	if err != nil || (destination.Scheme != "http" && destination.Scheme != "https") || destination.Host == "" {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := interstitialTemplate.Execute(w, struct {
+		URL  string
+		Host string
+	}{
+		URL:  destination.String(),
+		Host: destination.Hostname(),
+	}); err != nil {
+		p.API.LogError("Failed to render the interstitial page", "error", err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestInterstitial(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.SafeLinkMode = safeLinkModeInterstitial
+	p.configuration.InterstitialSigningKey = "signing-key"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{config: &model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://chat.example.com/")}}}
+
+	t.Run("requires a signing key", func(t *testing.T) {
+		p2 := newTestPlugin(t, true, "http,https", "http,https", "")
+		p2.configuration.SafeLinkMode = safeLinkModeInterstitial
+		assert.Error(t, p2.initConfiguration(p2.configuration))
+	})
+
+	t.Run("rewrites links to the interstitial page", func(t *testing.T) {
+		post, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: "See [the repo](https://github.com/mattermost?a=b)"})
+		require.Empty(t, errMessage)
+
+		expected := "https://chat.example.com/plugins/mattermost-plugin-link-filter/go?sig=" +
+			signURL("signing-key", "https://github.com/mattermost?a=b") + "&u=https%3A%2F%2Fgithub.com%2Fmattermost%3Fa%3Db"
+		assert.Equal(t, "See [the repo]("+expected+")", post.Message)
+		assert.NotNil(t, post.GetProp(OriginalURLsProp))
+
+		// Editing the post doesn't wrap the link twice
+		post, errMessage = p.MessageWillBeUpdated(nil, post, nil)
+		require.Empty(t, errMessage)
+		assert.Equal(t, "See [the repo]("+expected+")", post.Message)
+	})
+
+	serve := func(method, rawURL, signature string) *httptest.ResponseRecorder {
+		query := url.Values{}
+		query.Set("u", rawURL)
+		query.Set("sig", signature)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, httptest.NewRequest(method, interstitialPath+"?"+query.Encode(), nil))
+		return w
+	}
+
+	t.Run("shows the destination of signed links", func(t *testing.T) {
+		w := serve(http.MethodGet, "https://github.com/mattermost?a=b&c=<d>", signURL("signing-key", "https://github.com/mattermost?a=b&c=<d>"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "github.com")
+		assert.Contains(t, w.Body.String(), `href="https://github.com/mattermost?a=b&amp;c=%3cd%3e"`)
+		assert.NotContains(t, w.Body.String(), "<d>")
+		assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	})
+
+	t.Run("rejects links with an invalid signature", func(t *testing.T) {
+		w := serve(http.MethodGet, "https://evil.example.com", signURL("signing-key", "https://github.com"))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = serve(http.MethodGet, "https://evil.example.com", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("rejects non-web destinations", func(t *testing.T) {
+		w := serve(http.MethodGet, "javascript:alert(1)", signURL("signing-key", "javascript:alert(1)"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("rejects other methods", func(t *testing.T) {
+		w := serve(http.MethodPost, "https://github.com", signURL("signing-key", "https://github.com"))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("returns not found for unknown paths", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
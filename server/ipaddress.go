@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const checkIPAddresses = "ip_addresses"
+
+// How links to private IP addresses are handled.
+const (
+	privateIPLinksAllow    = "allow"
+	privateIPLinksInternal = "internal"
+	privateIPLinksDeny     = "deny"
+)
+
+// parseCIDRList parses a comma separated list of CIDR ranges. Single addresses are accepted as
+// ranges of a single address.
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, entry := range util.TrimString(strings.Split(list, ",")) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR range %q", entry)
+		}
+		ranges = append(ranges, ipNet)
+	}
+
+	return ranges, nil
+}
+
+// findRange returns the first range containing the IP address, or nil.
+func findRange(ranges []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return ipNet
+		}
+	}
+	return nil
+}
+
+// isPrivateIP returns whether the IP address is only reachable within a private network.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// isInternalChannel returns whether the post is made in one of the channels where links to
+// private IP addresses are allowed. Channels are configured by ID or name.
+func (p *Plugin) isInternalChannel(post *model.Post) bool {
+	channels := util.TrimString(strings.Split(p.getConfiguration().InternalChannels, ","))
+	if post.ChannelId == "" || len(channels) == 0 {
+		return false
+	}
+	if slices.Contains(channels, post.ChannelId) {
+		return true
+	}
+
+	info, err := p.getChannelInfo(post.ChannelId)
+	if err != nil {
+		p.API.LogWarn("Failed to get the channel name", "channel_id", post.ChannelId, "error", err.Error())
+		return false
+	}
+
+	return slices.Contains(channels, info.name)
+}
+
+// checkIPAddresses flags the links to raw IP addresses which aren't allowed. Denied ranges take
+// precedence over allowed ranges, which take precedence over the private addresses handling and
+// the blocking of all IP addresses.
+func (p *Plugin) checkIPAddresses(u *detectedURL, post *model.Post) *violation {
+	if !u.canonical.isIP {
+		return nil
+	}
+	ip := net.ParseIP(u.canonical.host)
+	if ip == nil {
+		return nil
+	}
+
+	configuration := p.getConfiguration()
+	newViolation := func(reason string) *violation {
+		return &violation{
+			url:    u,
+			check:  checkIPAddresses,
+			action: configuration.IPLinkAction,
+			reason: reason,
+		}
+	}
+
+	if ipNet := findRange(configuration.ipDenyList, ip); ipNet != nil {
+		return newViolation(fmt.Sprintf("The link to `%s` points to the denied range `%s`.", u.canonical.String(), ipNet))
+	}
+	if findRange(configuration.ipAllowList, ip) != nil {
+		return nil
+	}
+
+	if isPrivateIP(ip) {
+		switch configuration.PrivateIPLinks {
+		case privateIPLinksDeny:
+			return newViolation(fmt.Sprintf("The link to `%s` points to a private IP address.", u.canonical.String()))
+		case privateIPLinksInternal:
+			if !p.isInternalChannel(post) {
+				return newViolation(fmt.Sprintf("The link to `%s` points to a private IP address, which is only allowed in internal channels.", u.canonical.String()))
+			}
+			return nil
+		}
+	}
+
+	if configuration.BlockIPLinks {
+		return newViolation(fmt.Sprintf("The link to `%s` points to an IP address instead of a domain.", u.canonical.String()))
+	}
+
+	return nil
+}
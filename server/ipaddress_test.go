@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	ranges, err := parseCIDRList("10.0.0.0/8, 192.168.1.1 ,2001:db8::/32")
+	require.NoError(t, err)
+	require.Len(t, ranges, 3)
+	assert.Equal(t, "192.168.1.1/32", ranges[1].String())
+
+	_, err = parseCIDRList("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = parseCIDRList("intranet")
+	assert.Error(t, err)
+}
+
+func TestCheckIPAddresses(t *testing.T) {
+	var tests = []struct {
+		name           string
+		blockIPLinks   bool
+		privateIPLinks string
+		channelID      string
+		url            string
+		allowed        bool
+	}{
+		{name: "domain", blockIPLinks: true, url: "http://example.com", allowed: true},
+		{name: "public IP", url: "http://8.8.8.8", allowed: true},
+		{name: "blocked public IP", blockIPLinks: true, url: "http://8.8.8.8", allowed: false},
+		{name: "blocked IPv6", blockIPLinks: true, url: "http://[2001:4860::8888]/", allowed: false},
+		{name: "blocked numeric IP", blockIPLinks: true, url: "http://134744072/", allowed: false},
+		{name: "allowed range", blockIPLinks: true, url: "http://203.0.113.7/", allowed: true},
+		{name: "allowed address", blockIPLinks: true, privateIPLinks: privateIPLinksDeny, url: "http://10.1.2.3/", allowed: true},
+		{name: "denied range", url: "http://198.51.100.1/", allowed: false},
+		{name: "private IP allowed", privateIPLinks: privateIPLinksAllow, url: "http://10.0.0.5/admin", allowed: true},
+		{name: "private IP blocked with all IPs", blockIPLinks: true, privateIPLinks: privateIPLinksAllow, url: "http://10.0.0.5/admin", allowed: false},
+		{name: "private IP denied", privateIPLinks: privateIPLinksDeny, url: "http://10.0.0.5/admin", allowed: false},
+		{name: "loopback denied", privateIPLinks: privateIPLinksDeny, url: "http://127.0.0.1:8080/", allowed: false},
+		{name: "private IP outside internal channels", privateIPLinks: privateIPLinksInternal, channelID: "channel1", url: "http://192.168.0.1/", allowed: false},
+		{name: "private IP in internal channel by ID", blockIPLinks: true, privateIPLinks: privateIPLinksInternal, channelID: "channel2", url: "http://192.168.0.1/", allowed: true},
+		{name: "private IP in internal channel by name", privateIPLinks: privateIPLinksInternal, channelID: "channel3", url: "http://[fd00::1]/", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := configureTestPlugin(t, newTestPlugin(t, false, "http,https", "", ""), &mockAPI{channels: map[string]*model.Channel{
+				"channel1": {Id: "channel1", Name: "town-square"},
+				"channel2": {Id: "channel2", Name: "infra"},
+				"channel3": {Id: "channel3", Name: "ops"},
+			}}, func(c *configuration) {
+				c.IPLinkAction = actionReject
+				c.BlockIPLinks = test.blockIPLinks
+				c.IPAllowList = "203.0.113.0/24, 10.1.2.3"
+				c.IPDenyList = "198.51.100.0/24"
+				c.PrivateIPLinks = test.privateIPLinks
+				c.InternalChannels = "ops, channel2"
+			})
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", ChannelId: test.channelID, Message: test.url}, time.Now())
+			if test.allowed {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Len(t, reasons, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// linkStats summarizes the links of a post, as counted by the link limits.
+type linkStats struct {
+	embeddedLinks int
+	plainLinks    int
+	hosts         map[string]struct{}
+	// embeddedLength and plainLength are the number of characters taken by the links.
+	embeddedLength int
+	plainLength    int
+}
+
+// getLinkStats counts the links of the post. Links rewritten to prevent autolinking aren't counted.
+func getLinkStats(detectedURLs []*detectedURL) *linkStats {
+	stats := &linkStats{hosts: make(map[string]struct{})}
+	for _, u := range detectedURLs {
+		if u.rewritten {
+			continue
+		}
+
+		if u.isPlainText {
+			stats.plainLinks++
+			stats.plainLength += utf8.RuneCountInString(u.originalText)
+		} else {
+			stats.embeddedLinks++
+			stats.embeddedLength += utf8.RuneCountInString(u.originalText)
+		}
+
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		if u.canonical.host != "" {
+			stats.hosts[u.canonical.host] = struct{}{}
+		}
+	}
+
+	return stats
+}
+
+// checkLinkLimits returns the reasons why the post exceeds the configured link limits, naming the
+// limits exceeded. The link density limits only apply to posts with more than one link of the
+// kind, so a post made of a single link is never rejected.
+func (p *Plugin) checkLinkLimits(detectedURLs []*detectedURL, post *model.Post, now time.Time) []string {
+	if !p.isRuleActive(ruleLinkLimits, now) {
+		return nil
+	}
+
+	configuration := p.getConfiguration()
+	stats := getLinkStats(detectedURLs)
+	messageLength := utf8.RuneCountInString(post.Message)
+
+	var reasons []string
+	if configuration.MaxEmbeddedLinks > 0 && stats.embeddedLinks > configuration.MaxEmbeddedLinks {
+		reasons = append(reasons, fmt.Sprintf("The post contains %d embedded links, the maximum is %d.", stats.embeddedLinks, configuration.MaxEmbeddedLinks))
+	}
+	if configuration.MaxPlainLinks > 0 && stats.plainLinks > configuration.MaxPlainLinks {
+		reasons = append(reasons, fmt.Sprintf("The post contains %d plain text links, the maximum is %d.", stats.plainLinks, configuration.MaxPlainLinks))
+	}
+	if configuration.MaxDistinctHosts > 0 && len(stats.hosts) > configuration.MaxDistinctHosts {
+		reasons = append(reasons, fmt.Sprintf("The post links to %d different hosts, the maximum is %d.", len(stats.hosts), configuration.MaxDistinctHosts))
+	}
+	if density := linkDensity(stats.embeddedLinks, stats.embeddedLength, messageLength); configuration.MaxEmbeddedLinkDensity > 0 && density > configuration.MaxEmbeddedLinkDensity {
+		reasons = append(reasons, fmt.Sprintf("Embedded links make up %d%% of the post, the maximum is %d%%.", density, configuration.MaxEmbeddedLinkDensity))
+	}
+	if density := linkDensity(stats.plainLinks, stats.plainLength, messageLength); configuration.MaxPlainLinkDensity > 0 && density > configuration.MaxPlainLinkDensity {
+		reasons = append(reasons, fmt.Sprintf("Plain text links make up %d%% of the post, the maximum is %d%%.", density, configuration.MaxPlainLinkDensity))
+	}
+
+	return reasons
+}
+
+// linkDensity returns the percentage of the message taken by links, or 0 if there is at most a
+// single link.
+func linkDensity(links, linksLength, messageLength int) int {
+	if links <= 1 || messageLength == 0 {
+		return 0
+	}
+
+	return linksLength * 100 / messageLength
+}
+
+// enforceLinkLimits rejects the post if it exceeds any of the link limits.
+func (p *Plugin) enforceLinkLimits(detectedURLs []*detectedURL, post *model.Post, isEdit bool, now time.Time) string {
+	reasons := p.checkLinkLimits(detectedURLs, post, now)
+	if len(reasons) == 0 {
+		return ""
+	}
+
+	p.rejectPost(post, isEdit, detectedURLs, reasons)
+	p.reportViolation(post, isEdit, nil, ruleLinkLimits, actionReject, strings.Join(reasons, " "))
+	return fmt.Sprintf("Link limits exceeded: %s", strings.Join(reasons, " "))
+}
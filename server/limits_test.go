@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCheckLinkLimits(t *testing.T) {
+	var tests = []struct {
+		name            string
+		configure       func(c *configuration)
+		message         string
+		expectedReasons []string
+	}{
+		{
+			name:      "allows posts within the limits",
+			configure: func(c *configuration) { c.MaxEmbeddedLinks = 2; c.MaxPlainLinks = 2; c.MaxDistinctHosts = 2 },
+			message:   "[a](https://a.com) [b](https://a.com/b) https://b.com https://b.com/c",
+		},
+		{
+			name:            "limits embedded links",
+			configure:       func(c *configuration) { c.MaxEmbeddedLinks = 1 },
+			message:         "[a](https://a.com) [b](https://b.com) https://c.com",
+			expectedReasons: []string{"The post contains 2 embedded links, the maximum is 1."},
+		},
+		{
+			name:            "limits plain links",
+			configure:       func(c *configuration) { c.MaxPlainLinks = 1 },
+			message:         "[a](https://a.com) https://b.com https://c.com",
+			expectedReasons: []string{"The post contains 2 plain text links, the maximum is 1."},
+		},
+		{
+			name:      "doesn't count rewritten links",
+			configure: func(c *configuration) { c.MaxPlainLinks = 1 },
+			message:   "tel://1234 tel://5678 https://b.com",
+		},
+		{
+			name:            "limits distinct hosts",
+			configure:       func(c *configuration) { c.MaxDistinctHosts = 2 },
+			message:         "https://a.com https://A.com/x [b](https://b.com) https://c.com",
+			expectedReasons: []string{"The post links to 3 different hosts, the maximum is 2."},
+		},
+		{
+			name:            "limits embedded link density",
+			configure:       func(c *configuration) { c.MaxEmbeddedLinkDensity = 50 },
+			message:         "[a](https://a.com)[b](https://b.com) hi",
+			expectedReasons: []string{"Embedded links make up 92% of the post, the maximum is 50%."},
+		},
+		{
+			name:            "limits plain link density",
+			configure:       func(c *configuration) { c.MaxPlainLinkDensity = 50 },
+			message:         "https://a.com https://b.com",
+			expectedReasons: []string{"Plain text links make up 96% of the post, the maximum is 50%."},
+		},
+		{
+			name:      "doesn't apply density to a single link",
+			configure: func(c *configuration) { c.MaxPlainLinkDensity = 50 },
+			message:   "https://a.com",
+		},
+		{
+			name: "names all the limits exceeded",
+			configure: func(c *configuration) {
+				c.MaxPlainLinks = 1
+				c.MaxDistinctHosts = 1
+			},
+			message: "https://a.com https://b.com",
+			expectedReasons: []string{
+				"The post contains 2 plain text links, the maximum is 1.",
+				"The post links to 2 different hosts, the maximum is 1.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPlugin(t, true, "http,https", "http,https", "tel")
+			test.configure(p.configuration)
+
+			post := &model.Post{Message: test.message}
+			detectedURLs := p.extractURLs(post)
+			p.canonicalizeURLs(detectedURLs)
+			post.Message = p.rewriteLinks(detectedURLs, post)
+
+			assert.Equal(t, test.expectedReasons, p.checkLinkLimits(detectedURLs, post, time.Now()))
+		})
+	}
+}
+
+func TestLinkLimitsHook(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.MaxPlainLinks = 1
+	mockAPI := &mockAPI{}
+	p.API = mockAPI
+
+	post, errMessage := p.MessageWillBePosted(nil, &model.Post{UserId: "user1", ChannelId: "channel1", Message: "https://a.com https://b.com"})
+	assert.Nil(t, post)
+	assert.Equal(t, "Link limits exceeded: The post contains 2 plain text links, the maximum is 1.", errMessage)
+	assert.Contains(t, mockAPI.sentEphemeralPost.Message, "The post contains 2 plain text links, the maximum is 1.")
+
+	// Invalid schemes are still reported first
+	_, errMessage = p.MessageWillBePosted(nil, &model.Post{UserId: "user1", ChannelId: "channel1", Message: "s3://a.com https://b.com"})
+	assert.Equal(t, "Schemes not allowed: s3", errMessage)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const checkMismatchedLinkText = "mismatched_link_text"
+
+// displayedDomainRegex matches link texts which look like a domain, optionally followed by a path,
+// e.g. bank.com or www.bank.com/login.
+var displayedDomainRegex = regexp.MustCompile(`(?i)^(?:[\p{L}\p{N}-]+\.)+\p{L}{2,}(?:[/?#].*)?$`)
+
+// displayedSchemeRegex matches the scheme of a link text which looks like a URL.
+var displayedSchemeRegex = regexp.MustCompile(`^\w+$`)
+
+// displayedHost returns the host shown by the text of an embedded link, or an empty string if the
+// text doesn't look like a URL or a domain.
+func displayedHost(linkText string) string {
+	text := strings.Trim(strings.TrimSpace(linkText), "*_`<>")
+	if text == "" || strings.ContainsAny(text, " \t") {
+		return ""
+	}
+
+	if protocol, _, found := strings.Cut(text, "://"); found && displayedSchemeRegex.MatchString(protocol) {
+		return canonicalize(protocol, text).host
+	}
+	if !displayedDomainRegex.MatchString(text) {
+		return ""
+	}
+
+	host := text
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	canonicalHost, _, _ := canonicalizeHost(host)
+	return canonicalHost
+}
+
+// isSameSite returns whether the hosts are the same, or one is a subdomain of the other. The www
+// prefix is ignored.
+func isSameSite(displayed, actual string) bool {
+	displayed = strings.TrimPrefix(displayed, "www.")
+	actual = strings.TrimPrefix(actual, "www.")
+
+	return displayed == actual || strings.HasSuffix(actual, "."+displayed) || strings.HasSuffix(displayed, "."+actual)
+}
+
+// checkMismatchedLinkText flags embedded links whose text shows a URL or a domain which isn't the
+// actual destination of the link, e.g. [https://bank.com](https://evil.com). Links to a short link
+// may show either the short link or its destination.
+func (p *Plugin) checkMismatchedLinkText(u *detectedURL, _ *model.Post) *violation {
+	if u.isPlainText || u.canonical.host == "" || p.isSafeLink(u) {
+		return nil
+	}
+
+	displayed := displayedHost(u.linkText)
+	if displayed == "" || isSameSite(displayed, u.canonical.host) {
+		return nil
+	}
+	if u.shortLink != nil && isSameSite(displayed, u.shortLink.host) {
+		return nil
+	}
+
+	return &violation{
+		url:    u,
+		check:  checkMismatchedLinkText,
+		action: p.getConfiguration().MismatchedLinkTextAction,
+		reason: fmt.Sprintf("The link `%s` shows `%s` but actually points to `%s`.", u.originalText, displayed, u.canonical.host),
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestDisplayedHost(t *testing.T) {
+	for text, expected := range map[string]string{
+		"https://bank.com":        "bank.com",
+		"HTTPS://Bank.com/login":  "bank.com",
+		"www.bank.com":            "www.bank.com",
+		"bank.com/login?next=/":   "bank.com",
+		"**bank.com**":            "bank.com",
+		"`bank.com`":              "bank.com",
+		"bänk.com":                "xn--bnk-qla.com",
+		"click here":              "",
+		"the docs":                "",
+		"v1.2":                    "",
+		"readme.md in bank.com":   "",
+		"mailto:plugin@bank.com":  "",
+		"ftp://files.example.org": "files.example.org",
+	} {
+		assert.Equal(t, expected, displayedHost(text), text)
+	}
+}
+
+func TestCheckMismatchedLinkText(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.MismatchedLinkTextAction = actionReject
+	p.API = &mockAPI{}
+
+	var tests = []struct {
+		name           string
+		message        string
+		expectedReason string
+	}{
+		{
+			name:    "allows links with plain text",
+			message: "[click here](https://evil.com)",
+		},
+		{
+			name:    "allows links showing their destination",
+			message: "[https://bank.com/login](https://bank.com/login?session=1)",
+		},
+		{
+			name:    "allows links showing their domain",
+			message: "[bank.com](https://www.bank.com)",
+		},
+		{
+			name:    "allows links to subdomains of the shown domain",
+			message: "[bank.com](https://login.bank.com)",
+		},
+		{
+			name:           "flags links showing another URL",
+			message:        "[https://bank.com](https://evil.com)",
+			expectedReason: "The link `[https://bank.com](https://evil.com)` shows `bank.com` but actually points to `evil.com`.",
+		},
+		{
+			name:           "flags links showing another domain",
+			message:        "[www.bank.com/login](https://bank.com.evil.net/login)",
+			expectedReason: "The link `[www.bank.com/login](https://bank.com.evil.net/login)` shows `www.bank.com` but actually points to `bank.com.evil.net`.",
+		},
+		{
+			name:           "flags links hidden behind userinfo",
+			message:        "[bank.com](https://bank.com@evil.com)",
+			expectedReason: "shows `bank.com` but actually points to `evil.com`",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detectedURLs := p.extractURLs(&model.Post{Message: test.message})
+			require.Len(t, detectedURLs, 1)
+			p.canonicalizeURLs(detectedURLs)
+
+			v := p.checkMismatchedLinkText(detectedURLs[0], nil)
+			if test.expectedReason == "" {
+				assert.Nil(t, v)
+				return
+			}
+			require.NotNil(t, v)
+			assert.Contains(t, v.reason, test.expectedReason)
+		})
+	}
+
+	t.Run("ignores plain links", func(t *testing.T) {
+		detectedURLs := p.extractURLs(&model.Post{Message: "bank.com https://evil.com"})
+		p.canonicalizeURLs(detectedURLs)
+		require.Len(t, detectedURLs, 1)
+		assert.Nil(t, p.checkMismatchedLinkText(detectedURLs[0], nil))
+	})
+
+	t.Run("checks the URLs shown as link text only when disabled", func(t *testing.T) {
+		p := newTestPlugin(t, true, "http,https", "http,https", "")
+		p.API = &mockAPI{}
+
+		_, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: "[ftp://x](https://ok.com)"})
+		assert.Equal(t, "Schemes not allowed: ftp", errMessage)
+
+		p.configuration.MismatchedLinkTextAction = actionReject
+		detectedURLs := p.extractURLs(&model.Post{Message: "[ftp://x](https://ok.com)"})
+		require.Len(t, detectedURLs, 1)
+		assert.Equal(t, "https", detectedURLs[0].protocol)
+	})
+
+	t.Run("defangs mismatched links", func(t *testing.T) {
+		p.configuration.MismatchedLinkTextAction = actionDefang
+		post, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: "Log in at [https://bank.com](https://evil.com/login)"})
+		require.Empty(t, errMessage)
+		assert.Equal(t, "Log in at https://bank.com https(evil.com/login)", post.Message)
+	})
+
+	t.Run("ignores links rewritten to the interstitial page", func(t *testing.T) {
+		p.configuration.MismatchedLinkTextAction = actionReject
+		p.configuration.SafeLinkMode = safeLinkModeInterstitial
+		p.configuration.InterstitialSigningKey = "key"
+		require.NoError(t, p.initConfiguration(p.configuration))
+
+		post, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: "[https://bank.com](https://bank.com)"})
+		require.Empty(t, errMessage)
+		_, errMessage = p.MessageWillBeUpdated(nil, post, nil)
+		assert.Empty(t, errMessage)
+	})
+}
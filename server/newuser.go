@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// newUserTrackingSinceKey holds the time the plugin started counting posts. Users created
+	// before then are never restricted by the post count, since their older posts weren't counted.
+	newUserTrackingSinceKey = "new_user_tracking_since"
+	postCountKeyPrefix      = "postcount_"
+	postCountRetries        = 5
+
+	userTrustCacheTTL = 5 * time.Minute
+)
+
+// userTrust caches what is known about a user to decide whether they are a new user.
+type userTrust struct {
+	createAt  int64
+	isBot     bool
+	postCount int
+	expiresAt time.Time
+}
+
+// initNewUserTracking loads the time the plugin started counting posts, storing the current time
+// on the first activation.
+func (p *Plugin) initNewUserTracking() error {
+	now := []byte(strconv.FormatInt(model.GetMillis(), 10))
+	if _, appErr := p.API.KVSetWithOptions(newUserTrackingSinceKey, now, model.PluginKVSetOptions{Atomic: true}); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the post count tracking time")
+	}
+
+	value, appErr := p.API.KVGet(newUserTrackingSinceKey)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get the post count tracking time")
+	}
+
+	since, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the post count tracking time")
+	}
+	p.newUserTrackingSince = since
+
+	return nil
+}
+
+// isNewUser returns whether the user is below the configured account age or post count
+// thresholds at the given time. Users are considered established if they can't be looked up.
+func (p *Plugin) isNewUser(userID string, now time.Time) bool {
+	configuration := p.getConfiguration()
+	if configuration.NewUserAccountAgeHours <= 0 && configuration.NewUserMinPostCount <= 0 {
+		return false
+	}
+
+	trust, err := p.getUserTrust(userID)
+	if err != nil {
+		p.API.LogWarn("Failed to check whether the user is a new user", "user_id", userID, "error", err.Error())
+		return false
+	}
+	if trust.isBot {
+		return false
+	}
+
+	accountAge := now.Sub(time.UnixMilli(trust.createAt))
+	if configuration.NewUserAccountAgeHours > 0 && accountAge < time.Duration(configuration.NewUserAccountAgeHours)*time.Hour {
+		return true
+	}
+
+	return configuration.NewUserMinPostCount > 0 && p.isPostCountTracked(trust) && trust.postCount < configuration.NewUserMinPostCount
+}
+
+func (p *Plugin) isPostCountTracked(trust *userTrust) bool {
+	return trust.createAt >= p.newUserTrackingSince
+}
+
+// getUserTrust returns what is known about the user, looking the user up if it isn't cached.
+func (p *Plugin) getUserTrust(userID string) (*userTrust, error) {
+	p.userTrustLock.Lock()
+	cached, ok := p.userTrustCache[userID]
+	p.userTrustLock.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached, nil
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the user")
+	}
+
+	value, appErr := p.API.KVGet(postCountKeyPrefix + userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get the post count")
+	}
+	postCount, err := parsePostCount(value)
+	if err != nil {
+		return nil, err
+	}
+
+	trust := &userTrust{
+		createAt:  user.CreateAt,
+		isBot:     user.IsBot,
+		postCount: postCount,
+		expiresAt: time.Now().Add(userTrustCacheTTL),
+	}
+	p.cacheUserTrust(userID, trust)
+
+	return trust, nil
+}
+
+func (p *Plugin) cacheUserTrust(userID string, trust *userTrust) {
+	p.userTrustLock.Lock()
+	defer p.userTrustLock.Unlock()
+
+	if p.userTrustCache == nil {
+		p.userTrustCache = make(map[string]*userTrust)
+	}
+	p.userTrustCache[userID] = trust
+}
+
+// countUserPost increments the post count of users who haven't reached the post count threshold
+// yet. Once a user is established their posts aren't counted anymore.
+func (p *Plugin) countUserPost(post *model.Post) {
+	configuration := p.getConfiguration()
+	if configuration.NewUserMinPostCount <= 0 || post.IsSystemMessage() {
+		return
+	}
+
+	trust, err := p.getUserTrust(post.UserId)
+	if err != nil {
+		p.API.LogWarn("Failed to count the post of the user", "user_id", post.UserId, "error", err.Error())
+		return
+	}
+	if trust.isBot || !p.isPostCountTracked(trust) || trust.postCount >= configuration.NewUserMinPostCount {
+		return
+	}
+
+	postCount, err := p.incrementPostCount(post.UserId)
+	if err != nil {
+		p.API.LogWarn("Failed to count the post of the user", "user_id", post.UserId, "error", err.Error())
+		return
+	}
+
+	updated := *trust
+	updated.postCount = postCount
+	p.cacheUserTrust(post.UserId, &updated)
+}
+
+// incrementPostCount atomically increments the post count of the user in the KV store and returns
+// the new count.
+func (p *Plugin) incrementPostCount(userID string) (int, error) {
+	key := postCountKeyPrefix + userID
+	for i := 0; i < postCountRetries; i++ {
+		oldValue, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to get the post count")
+		}
+
+		postCount, err := parsePostCount(oldValue)
+		if err != nil {
+			return 0, err
+		}
+		postCount++
+
+		saved, appErr := p.API.KVSetWithOptions(key, []byte(strconv.Itoa(postCount)), model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldValue,
+		})
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to save the post count")
+		}
+		if saved {
+			return postCount, nil
+		}
+	}
+
+	return 0, errors.New("failed to save the post count after concurrent updates")
+}
+
+func parsePostCount(value []byte) (int, error) {
+	if value == nil {
+		return 0, nil
+	}
+
+	postCount, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse the post count")
+	}
+	return postCount, nil
+}
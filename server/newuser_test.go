@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestNewUserPolicy(t *testing.T) {
+	now := time.Now()
+	newPlugin := func(accountAgeHours, minPostCount int) (*Plugin, *mockAPI) {
+		p, mockAPI := configureTestPlugin(t, newTestPlugin(t, false, "http,https", "http,https", ""), &mockAPI{users: map[string]*model.User{
+			"new":         {Id: "new", CreateAt: now.Add(-time.Hour).UnixMilli()},
+			"established": {Id: "established", CreateAt: now.Add(-30 * 24 * time.Hour).UnixMilli()},
+			"bot":         {Id: "bot", CreateAt: now.Add(-time.Hour).UnixMilli(), IsBot: true},
+		}}, func(c *configuration) {
+			c.NewUserAccountAgeHours = accountAgeHours
+			c.NewUserMinPostCount = minPostCount
+			c.NewUserAllowedProtocolListLink = "https"
+			c.NewUserRejectPlainLinks = true
+		})
+		p.newUserTrackingSince = now.Add(-7 * 24 * time.Hour).UnixMilli()
+		return p, mockAPI
+	}
+	filter := func(p *Plugin, userID, message string) string {
+		post := &model.Post{UserId: userID, ChannelId: "channel1", Message: message}
+		_, errMessage := p.MessageWillBePosted(nil, post)
+		return errMessage
+	}
+
+	t.Run("restricts users younger than the account age", func(t *testing.T) {
+		p, _ := newPlugin(24, 0)
+
+		assert.Empty(t, filter(p, "new", "[link](https://example.com)"))
+		assert.Equal(t, "Schemes not allowed: http", filter(p, "new", "[link](http://example.com)"))
+		assert.Equal(t, "Schemes not allowed: https", filter(p, "new", "https://example.com"))
+
+		assert.Empty(t, filter(p, "established", "[link](http://example.com)"))
+		assert.Empty(t, filter(p, "established", "https://example.com"))
+		assert.Empty(t, filter(p, "bot", "https://example.com"))
+	})
+
+	t.Run("only filters the plain text links of new users if configured", func(t *testing.T) {
+		p, mockAPI := newPlugin(24, 0)
+		configureTestPlugin(t, p, mockAPI, func(c *configuration) {
+			c.NewUserRejectPlainLinks = false
+		})
+
+		assert.Empty(t, filter(p, "new", "https://example.com"))
+		assert.Equal(t, "Schemes not allowed: http", filter(p, "new", "[link](http://example.com)"))
+	})
+
+	t.Run("restricts users until they reach the post count", func(t *testing.T) {
+		p, mockAPI := newPlugin(0, 2)
+
+		assert.Equal(t, "Schemes not allowed: https", filter(p, "new", "https://example.com"))
+		for i := 0; i < 2; i++ {
+			p.MessageHasBeenPosted(nil, &model.Post{UserId: "new", Message: "hello"})
+		}
+		assert.Equal(t, []byte("2"), mockAPI.kv[postCountKeyPrefix+"new"])
+		assert.Empty(t, filter(p, "new", "https://example.com"))
+
+		// Established users' posts aren't counted
+		p.MessageHasBeenPosted(nil, &model.Post{UserId: "new", Message: "hello"})
+		assert.Equal(t, []byte("2"), mockAPI.kv[postCountKeyPrefix+"new"])
+	})
+
+	t.Run("doesn't restrict users created before posts were counted", func(t *testing.T) {
+		p, mockAPI := newPlugin(0, 2)
+
+		assert.Empty(t, filter(p, "established", "https://example.com"))
+		p.MessageHasBeenPosted(nil, &model.Post{UserId: "established", Message: "hello"})
+		assert.Nil(t, mockAPI.kv[postCountKeyPrefix+"established"])
+	})
+
+	t.Run("doesn't count system messages", func(t *testing.T) {
+		p, mockAPI := newPlugin(0, 2)
+
+		p.MessageHasBeenPosted(nil, &model.Post{UserId: "new", Type: model.POST_JOIN_CHANNEL})
+		assert.Nil(t, mockAPI.kv[postCountKeyPrefix+"new"])
+	})
+
+	t.Run("treats unknown users as established", func(t *testing.T) {
+		p, _ := newPlugin(24, 2)
+
+		assert.Empty(t, filter(p, "unknown", "https://example.com"))
+	})
+
+	t.Run("caches the user lookups", func(t *testing.T) {
+		p, mockAPI := newPlugin(24, 0)
+
+		assert.True(t, p.isNewUser("new", time.Now()))
+		delete(mockAPI.users, "new")
+		assert.True(t, p.isNewUser("new", time.Now()))
+	})
+}
+
+func TestInitNewUserTracking(t *testing.T) {
+	p := &Plugin{}
+	mockAPI := &mockAPI{}
+	p.API = mockAPI
+
+	require.NoError(t, p.initNewUserTracking())
+	since := p.newUserTrackingSince
+	assert.NotZero(t, since)
+
+	// The tracking time is kept across activations
+	mockAPI.kv[newUserTrackingSinceKey] = []byte("1000")
+	require.NoError(t, p.initNewUserTracking())
+	assert.Equal(t, int64(1000), p.newUserTrackingSince)
+}
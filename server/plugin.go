@@ -6,6 +6,10 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -15,9 +19,23 @@ type detectedURL struct {
 	protocol     string
 	host         string
 	originalText string
-	isPlainText  bool
-	positions    []int
-	rewritten    bool
+	// rawURL is the URL part of originalText, i.e. without the text of an embedded link.
+	rawURL string
+	// linkText is the text of an embedded link.
+	linkText    string
+	isPlainText bool
+	// positions are the indexes of the groups of the regular expression match in the post message.
+	// They're moved by replaceText whenever the message is rewritten.
+	positions []int
+	rewritten bool
+	// canonical is set by canonicalizeURLs and is the form host policies are evaluated against.
+	canonical *canonicalURL
+	// shortLink is the canonical form of the short link, when canonical was replaced by its destination.
+	shortLink *canonicalURL
+	// unresolvedShortLink is set if the URL is a short link whose destination couldn't be resolved.
+	unresolvedShortLink bool
+	// cleaned is set if cleanupLinks removed tracking parameters or redirect wrappers from the URL.
+	cleaned bool
 }
 
 type Plugin struct {
@@ -28,12 +46,36 @@ type Plugin struct {
 
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
-	configuration                  *configuration
-	embeddedLinkRegex              *regexp.Regexp
-	plainLinkRegex                 *regexp.Regexp
-	allowedProtocolsRegexLink      *regexp.Regexp
-	allowedProtocolsRegexPlainText *regexp.Regexp
-	rewriteProtocolList            []string
+	configuration     *configuration
+	embeddedLinkRegex *regexp.Regexp
+	plainLinkRegex    *regexp.Regexp
+
+	// newUserTrackingSince is the time, in milliseconds, the plugin started counting posts.
+	newUserTrackingSince int64
+	// userTrustLock synchronizes access to userTrustCache.
+	userTrustLock  sync.Mutex
+	userTrustCache map[string]*userTrust
+	// channelInfoLock synchronizes access to channelInfoCache.
+	channelInfoLock  sync.Mutex
+	channelInfoCache map[string]*channelInfo
+	botUserID        string
+	// violationNotifier sends the violation events to the webhook. It's nil while the plugin is inactive.
+	violationNotifier atomic.Pointer[violationNotifier]
+	// auditFileLock synchronizes access to auditFile.
+	auditFileLock sync.Mutex
+	auditFile     *lumberjack.Logger
+	// rateLimitWindows keeps the rate limit windows in memory, in front of the KV store.
+	rateLimitWindows rateLimitWindows
+	// rejections measures the rejection rate for the safety guard.
+	rejections rejectionTracker
+	// monitorModeLock synchronizes access to monitorMode and monitorModeCheckedAt.
+	monitorModeLock      sync.Mutex
+	monitorMode          *monitorMode
+	monitorModeCheckedAt time.Time
+	// shortLinkTableLock synchronizes access to shortLinkTable and shortLinkTableCheckedAt.
+	shortLinkTableLock      sync.Mutex
+	shortLinkTable          map[string]string
+	shortLinkTableCheckedAt time.Time
 }
 
 const (
@@ -54,9 +96,29 @@ const (
 func (p *Plugin) OnActivate() error {
 	p.initRegexes()
 
+	if err := p.registerCommands(); err != nil {
+		return err
+	}
+
+	if err := p.ensureBot(); err != nil {
+		return err
+	}
+
+	if err := p.initNewUserTracking(); err != nil {
+		return err
+	}
+	p.startViolationNotifier()
+	p.startReputationFeeds(nil, p.getConfiguration())
+
 	return nil
 }
 
+func (p *Plugin) OnDeactivate() error {
+	p.stopViolationNotifier()
+	p.stopReputationFeeds()
+	return p.stopAuditFile()
+}
+
 func (p *Plugin) initRegexes() {
 	p.embeddedLinkRegex = regexp.MustCompile(EmbeddedLinkRegexString)
 	p.plainLinkRegex = regexp.MustCompile(PlainLinkRegexString)
@@ -79,10 +141,15 @@ func (p *Plugin) extractURLs(post *model.Post) []*detectedURL {
 			protocol:     string(postText[loc[4]:loc[5]]),
 			host:         string(postText[loc[6]:loc[7]]),
 			originalText: string(postText[loc[0]:loc[1]]),
+			rawURL:       string(postText[loc[4]:loc[7]]),
+			linkText:     string(postText[loc[2]:loc[3]]),
 			positions:    loc,
 		})
 	}
 
+	// The URLs shown as the text of embedded links are checked against the destination of the link
+	// by the mismatched link text check, so they are only extracted when it's disabled
+	skipLinkText := isActionEnabled(p.getConfiguration().MismatchedLinkTextAction)
 	plainLinks := p.plainLinkRegex.FindAllSubmatchIndex(postText, -1)
 	for _, loc := range plainLinks {
 		// Skip if the URL starts with a parenthesis, which should be captured by the embedded link regex
@@ -90,10 +157,18 @@ func (p *Plugin) extractURLs(post *model.Post) []*detectedURL {
 			continue
 		}
 
+		if skipLinkText && isWithinEmbeddedLink(loc, embeddedLinks) {
+			continue
+		}
+		if !clipToLinkText(loc, embeddedLinks) || !clipToAutolink(loc, post.Message) {
+			continue
+		}
+
 		detectedURLs = append(detectedURLs, &detectedURL{
 			protocol:     string(postText[loc[2]:loc[3]]),
 			host:         string(postText[loc[4]:loc[5]]),
 			originalText: string(postText[loc[0]:loc[1]]),
+			rawURL:       string(postText[loc[0]:loc[1]]),
 			positions:    loc,
 			isPlainText:  true,
 		})
@@ -102,13 +177,66 @@ func (p *Plugin) extractURLs(post *model.Post) []*detectedURL {
 	return detectedURLs
 }
 
+// clipToLinkText ends the plain link at the end of the text of the embedded link it starts in, as
+// the plain link regex doesn't stop at the closing bracket. It returns false if nothing is left of
+// the host of the plain link.
+func clipToLinkText(loc []int, embeddedLinks [][]int) bool {
+	for _, embeddedLoc := range embeddedLinks {
+		if loc[0] >= embeddedLoc[2] && loc[0] < embeddedLoc[3] && loc[1] > embeddedLoc[3] {
+			loc[1] = embeddedLoc[3]
+			if loc[5] > embeddedLoc[3] {
+				loc[5] = embeddedLoc[3]
+			}
+			return loc[5] > loc[4]
+		}
+	}
+
+	return true
+}
+
+// clipToAutolink ends the plain link at the closing angle bracket of a markdown autolink, e.g.
+// <https://example.com>. It returns false if nothing is left of the host of the plain link.
+func clipToAutolink(loc []int, message string) bool {
+	if loc[0] == 0 || message[loc[0]-1] != '<' {
+		return true
+	}
+
+	if end := strings.IndexByte(message[loc[0]:loc[1]], '>'); end >= 0 {
+		loc[1] = loc[0] + end
+		if loc[5] > loc[1] {
+			loc[5] = loc[1]
+		}
+	}
+
+	return loc[5] > loc[4]
+}
+
+func isWithinEmbeddedLink(loc []int, embeddedLinks [][]int) bool {
+	for _, embeddedLoc := range embeddedLinks {
+		if loc[0] >= embeddedLoc[0] && loc[0] < embeddedLoc[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getInvalidProtocols returns the protocols that are not allowed in the post from the extracted URLs and the
-// plugin configuration.
-func (p *Plugin) getInvalidProtocols(detectedURLs []*detectedURL, _ *model.Post) []string {
-	configuration := p.getConfiguration()
+// plugin configuration, at the given time. Dangerous schemes are always invalid, including their obfuscated
+// variants the regular expressions don't match, unless unsafe schemes are allowed.
+func (p *Plugin) getInvalidProtocols(detectedURLs []*detectedURL, post *model.Post, now time.Time) []string {
+	policy := p.protocolPolicyForPost(post, now)
 
 	var invalidURLProtocols []string
 	set := make(map[string]struct{})
+	addInvalid := func(protocol string) {
+		if _, found := set[protocol]; !found {
+			invalidURLProtocols = append(invalidURLProtocols, protocol)
+			set[protocol] = struct{}{}
+		}
+	}
+	configuration := p.getConfiguration()
+	allowUnsafeSchemes := configuration.AllowUnsafeSchemes
 
 	for _, u := range detectedURLs {
 		// Skip if the URL has already been rewritten
@@ -117,45 +245,61 @@ func (p *Plugin) getInvalidProtocols(detectedURLs []*detectedURL, _ *model.Post)
 		}
 
 		// If it's a rewritable protocol in plain text format, mark it valid
-		if u.isPlainText && slices.Contains(p.rewriteProtocolList, u.protocol) {
+		if u.isPlainText && slices.Contains(configuration.rewriteProtocolList, u.protocol) {
 			u.rewritten = true
 			continue
 		}
 
+		// If protocol is dangerous, whatever the allowed protocols lists
+		if !allowUnsafeSchemes && isDangerousScheme(u.protocol) && (!u.isPlainText || policy.rejectPlainLinks) {
+			addInvalid(strings.ToLower(u.protocol))
+			continue
+		}
+
 		// If protocol is banned
 		_, alreadyPassed := set[u.protocol]
-		if !alreadyPassed && !u.isPlainText && (len(configuration.AllowedProtocolListLink) == 0 || !p.allowedProtocolsRegexLink.MatchString(u.protocol)) {
+		if !alreadyPassed && !u.isPlainText && !policy.allowsLink(u.protocol) {
 			invalidURLProtocols = append(invalidURLProtocols, u.protocol)
 			set[u.protocol] = struct{}{}
-		} else if !alreadyPassed && configuration.RejectPlainLinks && u.isPlainText && (len(configuration.AllowedProtocolListPlainText) == 0 || !p.allowedProtocolsRegexPlainText.MatchString(u.protocol)) {
+		} else if !alreadyPassed && u.isPlainText && !policy.allowsPlainText(u.protocol) {
 			invalidURLProtocols = append(invalidURLProtocols, u.protocol)
 			set[u.protocol] = struct{}{}
 		}
 	}
 
+	if !allowUnsafeSchemes {
+		for _, scheme := range findObfuscatedDangerousSchemes(post.Message, policy.rejectPlainLinks) {
+			addInvalid(scheme)
+		}
+	}
+
 	return invalidURLProtocols
 }
 
 // FilterPost filters the post based on the plugin configuration.
 // If the post is rejected, it sends an ephemeral post to the user and returns the error message with a nil post.
+// Posts with allowed schemes are then checked against the link limits and passed through the configured URL checks.
 func (p *Plugin) FilterPost(detectedURLs []*detectedURL, post *model.Post, isEdit bool) string {
-	configuration := p.getConfiguration()
-
-	invalidURLProtocols := p.getInvalidProtocols(detectedURLs, post)
+	now := time.Now()
+	invalidURLProtocols := p.getInvalidProtocols(detectedURLs, post, now)
 	if len(invalidURLProtocols) == 0 {
-		return ""
+		if errMessage := p.enforceLinkLimits(detectedURLs, post, isEdit, now); errMessage != "" {
+			return errMessage
+		}
+		return p.applyViolations(detectedURLs, p.runChecks(detectedURLs, post, now), post, isEdit)
 	}
 
-	WarningMessage := configuration.CreatePostWarningMessage
-	if isEdit {
-		WarningMessage = configuration.EditPostWarningMessage
-	}
+	return p.rejectInvalidProtocols(detectedURLs, post, isEdit, invalidURLProtocols)
+}
+
+// rejectInvalidProtocols sends an ephemeral post to the user about the schemes not allowed in the
+// post, and returns the rejection reason.
+func (p *Plugin) rejectInvalidProtocols(detectedURLs []*detectedURL, post *model.Post, isEdit bool, invalidURLProtocols []string) string {
+	WarningMessage := p.warningMessage(isEdit)
 	WarningMessage += fmt.Sprintf(InvalidURLSchemeMessage, strings.Join(invalidURLProtocols, ", "))
-	p.API.SendEphemeralPost(post.UserId, &model.Post{
-		ChannelId: post.ChannelId,
-		Message:   WarningMessage,
-		RootId:    post.RootId,
-	})
+	p.logDecision("Post rejected by the link filter", post, detectedURLs, []string{"Schemes not allowed: " + strings.Join(invalidURLProtocols, ", ")})
+	p.reportInvalidProtocols(detectedURLs, post, isEdit, invalidURLProtocols)
+	p.sendWarning(post, WarningMessage)
 
 	return fmt.Sprintf("Schemes not allowed: %s", strings.Join(invalidURLProtocols, ", "))
 }
@@ -170,50 +314,121 @@ func (p *Plugin) rewriteLinks(detectedURLs []*detectedURL, post *model.Post) str
 		return msg
 	}
 
-	// Normal processing for everything else
-	var builder strings.Builder
-	lastIndex := 0
+	rewriteProtocolList := p.getConfiguration().rewriteProtocolList
 
-	for i, u := range detectedURLs {
-		if u.isPlainText && slices.Contains(p.rewriteProtocolList, u.protocol) {
-			detectedURLs[i].rewritten = true
+	for _, u := range detectedURLs {
+		if u.isPlainText && slices.Contains(rewriteProtocolList, u.protocol) {
+			u.rewritten = true
 			// Trim any leading "//" from the host part
 			host := strings.TrimPrefix(u.host, "//")
 
 			rewritten := fmt.Sprintf("%s(%s)", u.protocol, host)
+			msg = replaceText(msg, detectedURLs, u, u.positions[0], u.positions[1], rewritten)
+		}
+	}
 
-			// Append the text before the detected URL
-			builder.WriteString(msg[lastIndex:u.positions[0]])
-			// Append the rewritten URL
-			builder.WriteString(rewritten)
-			// Update the last index to the end of the current URL
-			lastIndex = u.positions[1]
+	return msg
+}
+
+// replaceText replaces the text between start and end in the message, which belongs to the
+// replaced URL, and moves the positions of the detected URLs after it. The texts of the embedded
+// links around it are updated as well.
+func replaceText(msg string, detectedURLs []*detectedURL, replaced *detectedURL, start, end int, replacement string) string {
+	msg = msg[:start] + replacement + msg[end:]
+	shift := len(replacement) - (end - start)
+
+	for _, u := range detectedURLs {
+		for i, position := range u.positions {
+			if position >= end {
+				u.positions[i] = position + shift
+			}
 		}
+
+		if u != replaced && !u.isPlainText && u.positions[2] <= start && start+len(replacement) <= u.positions[3] {
+			u.linkText = msg[u.positions[2]:u.positions[3]]
+			u.originalText = msg[u.positions[0]:u.positions[1]]
+		}
+	}
+
+	return msg
+}
+
+// urlPositions returns the start and end of the URL part of the detected URL in the post message.
+func (u *detectedURL) urlPositions() (int, int) {
+	if u.isPlainText {
+		return u.positions[0], u.positions[1]
 	}
 
-	// Append the remaining text after the last URL
-	builder.WriteString(msg[lastIndex:])
-	return builder.String()
+	return u.positions[4], u.positions[7]
 }
 
 func (p *Plugin) MessageWillBePosted(_ *plugin.Context, post *model.Post) (*model.Post, string) {
+	now := time.Now()
+	mode := p.getMonitorMode(now)
+	if field := channelFieldForPostType(post.Type); field != nil && p.getConfiguration().ChannelFieldAction != channelFieldActionOff {
+		if mode != nil {
+			return p.monitorChannelFieldChange(post, field, now)
+		}
+		return p.filterChannelFieldChange(post, field)
+	}
+	if mode != nil {
+		return p.filterMonitoredPost(mode, post, false, now)
+	}
+
 	detectedURLs := p.extractURLs(post)
+	p.canonicalizeURLs(detectedURLs)
 	post.Message = p.rewriteLinks(detectedURLs, post)
+	p.cleanupLinks(detectedURLs, post)
+	p.resolveShortLinks(detectedURLs)
 
-	if errMessage := p.FilterPost(detectedURLs, post, false); errMessage != "" {
+	errMessage := p.FilterPost(detectedURLs, post, false)
+	p.recordFilterOutcome(detectedURLs, post, errMessage != "")
+	if errMessage != "" {
 		return nil, errMessage
 	}
+	if errMessage := p.enforceRateLimit(detectedURLs, post, false); errMessage != "" {
+		return nil, errMessage
+	}
+	p.reportRewrittenProtocols(detectedURLs, post, false)
+	p.auditCleanedLinks(detectedURLs, post, false)
+	p.rewriteSafeLinks(detectedURLs, post, false)
+	p.auditAllowedPost(detectedURLs, post, false)
 
 	return post, ""
 }
 
-func (p *Plugin) MessageWillBeUpdated(_ *plugin.Context, newPost *model.Post, _ *model.Post) (*model.Post, string) {
+func (p *Plugin) MessageHasBeenPosted(_ *plugin.Context, post *model.Post) {
+	p.countUserPost(post)
+}
+
+func (p *Plugin) MessageWillBeUpdated(_ *plugin.Context, newPost *model.Post, oldPost *model.Post) (*model.Post, string) {
+	now := time.Now()
+	if mode := p.getMonitorMode(now); mode != nil {
+		return p.filterMonitoredPost(mode, newPost, true, now)
+	}
+
 	detectedURLs := p.extractURLs(newPost)
+	addedURLs := detectedURLs
+	if oldPost != nil {
+		addedURLs = addedLinks(detectedURLs, p.extractURLs(oldPost))
+	}
+	p.canonicalizeURLs(detectedURLs)
 	newPost.Message = p.rewriteLinks(detectedURLs, newPost)
+	p.cleanupLinks(detectedURLs, newPost)
+	p.resolveShortLinks(detectedURLs)
 
-	if errMessage := p.FilterPost(detectedURLs, newPost, true); errMessage != "" {
+	errMessage := p.FilterPost(detectedURLs, newPost, true)
+	p.recordFilterOutcome(detectedURLs, newPost, errMessage != "")
+	if errMessage != "" {
+		return nil, errMessage
+	}
+	if errMessage := p.enforceRateLimit(addedURLs, newPost, true); errMessage != "" {
 		return nil, errMessage
 	}
+	p.reportRewrittenProtocols(detectedURLs, newPost, true)
+	p.auditCleanedLinks(detectedURLs, newPost, true)
+	p.rewriteSafeLinks(detectedURLs, newPost, true)
+	p.auditAllowedPost(detectedURLs, newPost, true)
 
 	return newPost, ""
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,6 +34,23 @@ func newTestPlugin(t *testing.T, rejectPlainLinks bool, allowedProtocolListLink,
 	return p
 }
 
+// configureTestPlugin applies configure to the configuration of the test plugin, computes its
+// derived values again and sets the mock API, which is returned for the assertions. A nil mock
+// API is replaced by an empty one.
+func configureTestPlugin(t *testing.T, p *Plugin, api *mockAPI, configure func(c *configuration)) (*Plugin, *mockAPI) {
+	if configure != nil {
+		configure(p.configuration)
+	}
+	require.NoError(t, p.initConfiguration(p.configuration))
+
+	if api == nil {
+		api = &mockAPI{}
+	}
+	p.API = api
+
+	return p, api
+}
+
 // TestExtractURLs tests the extractURLs method
 func TestExtractURLs(t *testing.T) {
 	// Test with empty allowed protocols since that shouldn't matter for the URL extraction
@@ -122,6 +143,27 @@ func TestExtractURLs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "extracts the text of an embedded link as a plain link",
+			in: &model.Post{
+				Message: "[https://bank.com](https://evil.com)",
+			},
+			expectedCount: 2,
+			expectedURLs: []*detectedURL{
+				{
+					protocol:     "https",
+					host:         "evil.com",
+					originalText: "[https://bank.com](https://evil.com)",
+					isPlainText:  false,
+				},
+				{
+					protocol:     "https",
+					host:         "bank.com",
+					originalText: "https://bank.com",
+					isPlainText:  true,
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -230,7 +272,7 @@ func TestGetInvalidURLsWithPlainLinks(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			detectedURLs := p.extractURLs(test.in)
-			invalidURLs := p.getInvalidProtocols(detectedURLs, test.in)
+			invalidURLs := p.getInvalidProtocols(detectedURLs, test.in, time.Now())
 			assert.ElementsMatch(t, test.expectedURLs, invalidURLs)
 		})
 	}
@@ -299,7 +341,7 @@ func TestGetInvalidURLsWithRewriteProtocols(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			detectedURLs := p.extractURLs(test.in)
 			_ = p.rewriteLinks(detectedURLs, test.in)
-			invalidProtocols := p.getInvalidProtocols(detectedURLs, test.in)
+			invalidProtocols := p.getInvalidProtocols(detectedURLs, test.in, time.Now())
 			assert.ElementsMatch(t, test.invalidProtocols, invalidProtocols)
 		})
 	}
@@ -412,6 +454,20 @@ func TestRewriteLinks(t *testing.T) {
 type mockAPI struct {
 	plugin.API
 	sentEphemeralPost *model.Post
+	config            *model.Config
+	kv                map[string][]byte
+	users             map[string]*model.User
+	channels          map[string]*model.Channel
+	admins            map[string]bool
+	infoLogs          []mockLog
+	savedConfig       map[string]interface{}
+	createdPosts      []*model.Post
+}
+
+// mockLog is a record of the server logs, with its key value pairs.
+type mockLog struct {
+	message       string
+	keyValuePairs []interface{}
 }
 
 func (m *mockAPI) SendEphemeralPost(_ string, post *model.Post) *model.Post {
@@ -419,6 +475,119 @@ func (m *mockAPI) SendEphemeralPost(_ string, post *model.Post) *model.Post {
 	return post
 }
 
+func (m *mockAPI) GetConfig() *model.Config {
+	if m.config == nil {
+		return &model.Config{}
+	}
+	return m.config
+}
+
+func (m *mockAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+	}
+	return user, nil
+}
+
+func (m *mockAPI) GetChannel(channelID string) (*model.Channel, *model.AppError) {
+	channel, ok := m.channels[channelID]
+	if !ok {
+		return nil, model.NewAppError("GetChannel", "not_found", nil, "", http.StatusNotFound)
+	}
+	return channel.DeepCopy(), nil
+}
+
+func (m *mockAPI) UpdateChannel(channel *model.Channel) (*model.Channel, *model.AppError) {
+	m.channels[channel.Id] = channel.DeepCopy()
+	return channel, nil
+}
+
+func (m *mockAPI) GetPublicChannelsForTeam(teamID string, page, perPage int) ([]*model.Channel, *model.AppError) {
+	var channels []*model.Channel
+	for _, channel := range m.channels {
+		if channel.TeamId == teamID && channel.Type == model.CHANNEL_OPEN {
+			channels = append(channels, channel.DeepCopy())
+		}
+	}
+	if page > 0 {
+		return nil, nil
+	}
+	return channels, nil
+}
+
+func (m *mockAPI) SavePluginConfig(config map[string]interface{}) *model.AppError {
+	m.savedConfig = config
+	return nil
+}
+
+// LoadPluginConfiguration loads the configuration saved with SavePluginConfig, like the server.
+func (m *mockAPI) LoadPluginConfiguration(dest interface{}) error {
+	data, err := json.Marshal(m.savedConfig)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (m *mockAPI) GetUsers(options *model.UserGetOptions) ([]*model.User, *model.AppError) {
+	var users []*model.User
+	for _, user := range m.users {
+		if options.Role == "" || user.IsInRole(options.Role) {
+			users = append(users, user)
+		}
+	}
+	if options.Page > 0 {
+		return nil, nil
+	}
+	return users, nil
+}
+
+func (m *mockAPI) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: model.GetDMNameFromIds(userID1, userID2), Type: model.CHANNEL_DIRECT}, nil
+}
+
+func (m *mockAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	m.createdPosts = append(m.createdPosts, post)
+	return post, nil
+}
+
+func (m *mockAPI) HasPermissionTo(userID string, _ *model.Permission) bool {
+	return m.admins[userID]
+}
+
+func (m *mockAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return m.kv[key], nil
+}
+
+func (m *mockAPI) KVSet(key string, value []byte) *model.AppError {
+	if m.kv == nil {
+		m.kv = make(map[string][]byte)
+	}
+	m.kv[key] = value
+	return nil
+}
+
+func (m *mockAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if options.Atomic && !bytes.Equal(m.kv[key], options.OldValue) {
+		return false, nil
+	}
+	return true, m.KVSet(key, value)
+}
+
+func (m *mockAPI) KVDelete(key string) *model.AppError {
+	delete(m.kv, key)
+	return nil
+}
+
+func (m *mockAPI) LogDebug(string, ...interface{}) {}
+func (m *mockAPI) LogWarn(string, ...interface{})  {}
+
+func (m *mockAPI) LogInfo(message string, keyValuePairs ...interface{}) {
+	m.infoLogs = append(m.infoLogs, mockLog{message: message, keyValuePairs: keyValuePairs})
+}
+func (m *mockAPI) LogError(string, ...interface{}) {}
+
 // TestFilterPost tests the FilterPost method
 func TestFilterPost(t *testing.T) {
 	p := newTestPlugin(t, true, "http,https,mailto", "http,https,mailto", "tel")
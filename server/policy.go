@@ -0,0 +1,85 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+// Names of the protocol policies, as shown in reports.
+const (
+	policyDefault       = "default"
+	policyNewUser       = "new_user"
+	policyDirectMessage = "direct_message"
+	policyOffHours      = "off_hours"
+)
+
+// protocolPolicy is a set of allowed protocols for embedded and plain text links. Each post is
+// evaluated against a single policy, chosen by protocolPolicyForPost.
+type protocolPolicy struct {
+	name string
+	// allowedLink matches the protocols allowed in embedded links. It's nil if no protocol is allowed.
+	allowedLink *regexp.Regexp
+	// allowedPlainText matches the protocols allowed in plain text links. It's nil if no protocol is allowed.
+	allowedPlainText *regexp.Regexp
+	rejectPlainLinks bool
+}
+
+// newProtocolPolicy builds a policy from comma separated lists of protocols. An empty list
+// allows no protocol at all.
+func newProtocolPolicy(name, allowedProtocolListLink, allowedProtocolListPlainText string, rejectPlainLinks bool) (*protocolPolicy, error) {
+	policy := &protocolPolicy{
+		name:             name,
+		rejectPlainLinks: rejectPlainLinks,
+	}
+
+	var err error
+	if policy.allowedLink, err = compileProtocolList(allowedProtocolListLink); err != nil {
+		return nil, err
+	}
+	if policy.allowedPlainText, err = compileProtocolList(allowedProtocolListPlainText); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func compileProtocolList(protocolList string) (*regexp.Regexp, error) {
+	if len(util.TrimString(strings.Split(protocolList, ","))) == 0 {
+		return nil, nil
+	}
+
+	return regexp.Compile(wordListToRegex(protocolList))
+}
+
+// allowsLink returns whether the protocol is allowed in embedded links.
+func (pp *protocolPolicy) allowsLink(protocol string) bool {
+	return pp.allowedLink != nil && pp.allowedLink.MatchString(protocol)
+}
+
+// allowsPlainText returns whether the protocol is allowed in plain text links.
+func (pp *protocolPolicy) allowsPlainText(protocol string) bool {
+	return !pp.rejectPlainLinks || (pp.allowedPlainText != nil && pp.allowedPlainText.MatchString(protocol))
+}
+
+// protocolPolicyForPost returns the policy the post is evaluated against at the given time. The
+// new user policy takes precedence, then the off-hours policy, as they're meant to be the
+// strictest. The off-hours policy only applies while one of its schedules is active.
+func (p *Plugin) protocolPolicyForPost(post *model.Post, now time.Time) *protocolPolicy {
+	configuration := p.getConfiguration()
+	if post != nil && p.isRuleActive(policyNewUser, now) && p.isNewUser(post.UserId, now) {
+		return configuration.newUserPolicy
+	}
+	if len(configuration.schedules[policyOffHours]) > 0 && p.isRuleActive(policyOffHours, now) {
+		return configuration.offHoursPolicy
+	}
+	if configuration.DirectMessagePolicy && p.isRuleActive(policyDirectMessage, now) && p.isDirectMessage(post) {
+		return configuration.directMessagePolicy
+	}
+
+	return configuration.defaultPolicy
+}
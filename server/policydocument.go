@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// policyDocumentVersion is the version of the format of the exported policy documents. It
+	// must be increased when a change of the configuration isn't backward compatible.
+	policyDocumentVersion = 1
+
+	// policyAPIPath is the path of the policy export and import endpoint, relative to the plugin URL.
+	policyAPIPath = "/api/v1/policy"
+
+	// maxPolicyDocumentSize is the maximum size, in bytes, of an imported policy document.
+	maxPolicyDocumentSize = 1024 * 1024
+)
+
+// secretSettings are never exported, and keep their current value when a document is imported.
+var secretSettings = []string{"InterstitialSigningKey", "ReputationServiceSecret", "ViolationWebhookSecret"}
+
+// policyDocument is the full configuration of the plugin, in a form which can be moved between
+// servers.
+type policyDocument struct {
+	Version       int             `json:"version"`
+	PluginVersion string          `json:"plugin_version"`
+	Configuration json.RawMessage `json:"configuration"`
+}
+
+// settingChange is a setting whose value differs between two configurations.
+type settingChange struct {
+	Setting string      `json:"setting"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+}
+
+// exportPolicy returns the current configuration as a policy document, without the secrets.
+func (p *Plugin) exportPolicy() ([]byte, error) {
+	settings := configurationSettings(p.getConfiguration())
+	for _, name := range secretSettings {
+		delete(settings, name)
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the configuration")
+	}
+
+	document, err := json.MarshalIndent(policyDocument{
+		Version:       policyDocumentVersion,
+		PluginVersion: manifest.Version,
+		Configuration: data,
+	}, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the policy document")
+	}
+
+	return document, nil
+}
+
+// importPolicy validates the policy document and returns the configuration it describes, with the
+// changes from the current configuration. Settings missing from the document keep their current
+// value, as do the secrets.
+func (p *Plugin) importPolicy(data []byte) (*configuration, []settingChange, error) {
+	var document policyDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse the policy document")
+	}
+	if document.Version != policyDocumentVersion {
+		return nil, nil, errors.Errorf("unsupported policy document version %d, expected %d", document.Version, policyDocumentVersion)
+	}
+	if len(document.Configuration) == 0 {
+		return nil, nil, errors.New("the policy document has no configuration")
+	}
+
+	current := p.getConfiguration()
+	imported := current.Clone()
+	decoder := json.NewDecoder(bytes.NewReader(document.Configuration))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(imported); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid configuration")
+	}
+
+	copySecrets(imported, current)
+
+	if err := validateConfiguration(imported); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid configuration")
+	}
+
+	return imported, diffConfigurations(current, imported), nil
+}
+
+// copySecrets sets the secrets of the configuration to their value in another configuration.
+func copySecrets(to, from *configuration) {
+	toValue := reflect.ValueOf(to).Elem()
+	fromValue := reflect.ValueOf(from).Elem()
+	for _, name := range secretSettings {
+		toValue.FieldByName(name).Set(fromValue.FieldByName(name))
+	}
+}
+
+// withoutSecrets returns a copy of the configuration with its secrets cleared.
+func withoutSecrets(c *configuration) *configuration {
+	stripped := c.Clone()
+	copySecrets(stripped, &configuration{})
+	return stripped
+}
+
+// applyConfiguration saves the configuration in the server configuration, which in turn calls
+// OnConfigurationChange.
+func (p *Plugin) applyConfiguration(configuration *configuration) error {
+	// The System Console stores the plugin settings with lower case keys
+	settings := map[string]interface{}{}
+	for name, value := range configurationSettings(configuration) {
+		settings[strings.ToLower(name)] = value
+	}
+
+	if appErr := p.API.SavePluginConfig(settings); appErr != nil {
+		return errors.Wrap(appErr, "failed to save the configuration")
+	}
+
+	return nil
+}
+
+// validateConfiguration returns an error if the configuration would be refused by
+// OnConfigurationChange.
+func validateConfiguration(configuration *configuration) error {
+	_, err := newDerivedConfiguration(configuration)
+	return err
+}
+
+// configurationSettings returns the settings of the configuration by name.
+func configurationSettings(configuration *configuration) map[string]interface{} {
+	settings := map[string]interface{}{}
+	value := reflect.ValueOf(configuration).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if !value.Type().Field(i).IsExported() {
+			continue
+		}
+		settings[value.Type().Field(i).Name] = value.Field(i).Interface()
+	}
+
+	return settings
+}
+
+// diffConfigurations returns the settings changed between the configurations, in the order they
+// are declared.
+func diffConfigurations(before, after *configuration) []settingChange {
+	oldValue := reflect.ValueOf(before).Elem()
+	newValue := reflect.ValueOf(after).Elem()
+
+	var changes []settingChange
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !oldValue.Type().Field(i).IsExported() || oldValue.Field(i).Interface() == newValue.Field(i).Interface() {
+			continue
+		}
+		changes = append(changes, settingChange{
+			Setting: oldValue.Type().Field(i).Name,
+			Old:     oldValue.Field(i).Interface(),
+			New:     newValue.Field(i).Interface(),
+		})
+	}
+
+	return changes
+}
+
+// formatChanges returns the changes as a Markdown list.
+func formatChanges(changes []settingChange) string {
+	items := make([]string, 0, len(changes))
+	for _, change := range changes {
+		secret := slices.Contains(secretSettings, change.Setting)
+		items = append(items, fmt.Sprintf("`%s`: %s → %s", change.Setting, formatSettingValue(change.Old, secret), formatSettingValue(change.New, secret)))
+	}
+
+	return bulletList(items)
+}
+
+func formatSettingValue(value interface{}, secret bool) string {
+	if secret {
+		return "(secret)"
+	}
+	if s, ok := value.(string); ok {
+		return "`" + strconv.Quote(s) + "`"
+	}
+
+	return fmt.Sprintf("`%v`", value)
+}
+
+// executeExportCommand returns the current configuration as a policy document.
+func (p *Plugin) executeExportCommand(_ *model.CommandArgs, _ []string) string {
+	document, err := p.exportPolicy()
+	if err != nil {
+		return fmt.Sprintf("Failed to export the configuration: %s", err.Error())
+	}
+
+	return "```json\n" + string(document) + "\n```"
+}
+
+// executeImportCommand shows the changes the policy document given as parameter would make to the
+// configuration, and applies them with --apply. The document is read from the raw command, so the
+// spaces and line breaks of the settings are kept.
+func (p *Plugin) executeImportCommand(args *model.CommandArgs, parameters []string) string {
+	apply := len(parameters) > 0 && parameters[0] == "--apply"
+
+	// Skip the trigger, the subcommand and --apply
+	fields := 2
+	if apply {
+		fields++
+	}
+	text := commandText(args.Command, fields)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimSpace(strings.Trim(text, "`"))
+	if text == "" {
+		return fmt.Sprintf("Please provide a policy document exported with `/%s export`.", commandTrigger)
+	}
+
+	imported, changes, err := p.importPolicy([]byte(text))
+	if err != nil {
+		return fmt.Sprintf("Failed to import the policy document: %s", err.Error())
+	}
+	if len(changes) == 0 {
+		return "The policy document matches the current configuration."
+	}
+	if !apply {
+		return fmt.Sprintf("Importing the policy document would change:\n%s\nRun `/%s import --apply <document>` to apply the changes.", formatChanges(changes), commandTrigger)
+	}
+
+	if err := p.applyConfiguration(imported); err != nil {
+		return fmt.Sprintf("Failed to apply the policy document: %s", err.Error())
+	}
+
+	return fmt.Sprintf("The policy document has been imported, with the changes:\n%s", formatChanges(changes))
+}
+
+// handlePolicy exports the configuration as a policy document on GET, and imports a policy
+// document on POST. Like the import command, imports only return the changes, unless the apply
+// query parameter is true.
+// Only system admins can use the endpoint.
+func (p *Plugin) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		document, err := p.exportPolicy()
+		if err != nil {
+			p.API.LogError("Failed to export the configuration", "error", err.Error())
+			http.Error(w, "Failed to export the configuration", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(document)
+
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicyDocumentSize))
+		if err != nil {
+			http.Error(w, "Failed to read the policy document", http.StatusBadRequest)
+			return
+		}
+
+		imported, changes, err := p.importPolicy(data)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		apply := r.URL.Query().Get("apply") == "true" && len(changes) > 0
+		if apply {
+			if err := p.applyConfiguration(imported); err != nil {
+				p.API.LogError("Failed to apply the policy document", "error", err.Error())
+				http.Error(w, "Failed to apply the policy document", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Changes []settingChange `json:"changes"`
+			Applied bool            `json:"applied"`
+		}{
+			Changes: changes,
+			Applied: apply,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestPolicyDocument(t *testing.T) {
+	newPlugin := func() (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "http,https", "https", "tel"), &mockAPI{admins: map[string]bool{"admin": true}}, func(c *configuration) {
+			c.SafeLinkMode = safeLinkModeInterstitial
+			c.InterstitialSigningKey = "secret"
+			c.RateLimitLinks = 10
+		})
+	}
+
+	t.Run("exports the configuration without the secrets", func(t *testing.T) {
+		p, _ := newPlugin()
+
+		data, err := p.exportPolicy()
+		require.NoError(t, err)
+
+		var document struct {
+			Version       int                    `json:"version"`
+			PluginVersion string                 `json:"plugin_version"`
+			Configuration map[string]interface{} `json:"configuration"`
+		}
+		require.NoError(t, json.Unmarshal(data, &document))
+		assert.Equal(t, policyDocumentVersion, document.Version)
+		assert.Equal(t, manifest.Version, document.PluginVersion)
+		assert.Equal(t, "http,https", document.Configuration["AllowedProtocolListLink"])
+		assert.Equal(t, float64(10), document.Configuration["RateLimitLinks"])
+		assert.NotContains(t, document.Configuration, "InterstitialSigningKey")
+	})
+
+	t.Run("imports an exported document without changes", func(t *testing.T) {
+		p, _ := newPlugin()
+
+		data, err := p.exportPolicy()
+		require.NoError(t, err)
+
+		imported, changes, err := p.importPolicy(data)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+		assert.Equal(t, "secret", imported.InterstitialSigningKey)
+	})
+
+	t.Run("returns the changes of the imported document", func(t *testing.T) {
+		p, _ := newPlugin()
+
+		imported, changes, err := p.importPolicy([]byte(`{"version": 1, "configuration": {"AllowedProtocolListLink": "https", "RateLimitLinks": 5}}`))
+		require.NoError(t, err)
+		assert.Equal(t, []settingChange{
+			{Setting: "AllowedProtocolListLink", Old: "http,https", New: "https"},
+			{Setting: "RateLimitLinks", Old: 10, New: 5},
+		}, changes)
+		assert.Equal(t, "https", imported.AllowedProtocolListPlainText)
+	})
+
+	t.Run("rejects invalid documents", func(t *testing.T) {
+		p, _ := newPlugin()
+
+		for _, document := range []string{
+			`not json`,
+			`{"version": 2, "configuration": {}}`,
+			`{"version": 1}`,
+			`{"version": 1, "configuration": {"UnknownSetting": true}}`,
+			`{"version": 1, "configuration": {"RateLimitLinks": "ten"}}`,
+			`{"version": 1, "configuration": {"Schedules": "off_hours: someday"}}`,
+		} {
+			_, _, err := p.importPolicy([]byte(document))
+			assert.Error(t, err, document)
+		}
+	})
+
+	t.Run("shows the changes before applying them with the command", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+		document := "```json\n{\"version\": 1, \"configuration\": {\"AllowedProtocolListLink\": \"https\"}}\n```"
+
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter import " + document, UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Importing the policy document would change:\n* `AllowedProtocolListLink`: `\"http,https\"` → `\"https\"`\nRun `/linkfilter import --apply <document>` to apply the changes.", response.Text)
+		assert.Nil(t, mockAPI.savedConfig)
+
+		response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter import --apply " + document, UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "The policy document has been imported")
+		require.NotNil(t, mockAPI.savedConfig)
+		assert.Equal(t, "https", mockAPI.savedConfig["allowedprotocollistlink"])
+		assert.Equal(t, "secret", mockAPI.savedConfig["interstitialsigningkey"])
+	})
+
+	t.Run("keeps the spaces and line breaks of the settings in the command", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+		configureTestPlugin(t, p, mockAPI, func(c *configuration) {
+			c.URLRules = "allow github.com/our-org/*\n\n  deny  evil.com"
+		})
+
+		document, err := p.exportPolicy()
+		require.NoError(t, err)
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter import\n```json\n" + string(document) + "\n```", UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "The policy document matches the current configuration.", response.Text)
+
+		response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter import --apply " + strings.ReplaceAll(string(document), "our-org", "other-org"), UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "`\"allow github.com/other-org/*\\n\\n  deny  evil.com\"`")
+	})
+
+	t.Run("serves the policy to system admins", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+		serve := func(method, target, userID, body string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, target, strings.NewReader(body))
+			if userID != "" {
+				r.Header.Set("Mattermost-User-Id", userID)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, r)
+			return w
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, policyAPIPath, "", "").Code)
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, policyAPIPath, "user1", "").Code)
+
+		w := serve(http.MethodGet, policyAPIPath, "admin", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"AllowedProtocolListLink": "http,https"`)
+
+		document := `{"version": 1, "configuration": {"RateLimitLinks": 5}}`
+		w = serve(http.MethodPost, policyAPIPath, "admin", document)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"changes": [{"setting": "RateLimitLinks", "old": 10, "new": 5}], "applied": false}`, w.Body.String())
+		assert.Nil(t, mockAPI.savedConfig, "the changes should only be shown by default")
+
+		w = serve(http.MethodPost, policyAPIPath+"?apply=false", "admin", document)
+		assert.JSONEq(t, `{"changes": [{"setting": "RateLimitLinks", "old": 10, "new": 5}], "applied": false}`, w.Body.String())
+		assert.Nil(t, mockAPI.savedConfig)
+
+		w = serve(http.MethodPost, policyAPIPath+"?apply=true", "admin", document)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"changes": [{"setting": "RateLimitLinks", "old": 10, "new": 5}], "applied": true}`, w.Body.String())
+		assert.Equal(t, 5, mockAPI.savedConfig["ratelimitlinks"])
+
+		w = serve(http.MethodPost, policyAPIPath, "admin", `{"version": 2}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unsupported policy document version 2")
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const (
+	checkPorts = "ports"
+
+	// anyScheme lists the ports allowed for the schemes without their own list.
+	anyScheme = "*"
+)
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	first int
+	last  int
+}
+
+// parseAllowedPorts parses one list of ports per line, in the form `<scheme>: <ports>`, e.g.
+// `https: 443, 8443` or `http: 80, 8000-8100`. The `*` scheme applies to the schemes without their
+// own list. It returns the allowed port ranges by scheme.
+func parseAllowedPorts(text string) (map[string][]portRange, error) {
+	allowedPorts := map[string][]portRange{}
+	for _, line := range util.TrimString(strings.Split(text, "\n")) {
+		scheme, ports, found := strings.Cut(line, ":")
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if !found || scheme == "" {
+			return nil, errors.Errorf("invalid allowed ports %q: expected <scheme>: <ports>", line)
+		}
+
+		for _, entry := range util.TrimString(strings.Split(ports, ",")) {
+			r, err := parsePortRange(entry)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid allowed ports %q", line)
+			}
+			allowedPorts[scheme] = append(allowedPorts[scheme], r)
+		}
+	}
+
+	return allowedPorts, nil
+}
+
+func parsePortRange(text string) (portRange, error) {
+	first, last, isRange := strings.Cut(text, "-")
+	if !isRange {
+		last = first
+	}
+
+	var r portRange
+	var err error
+	if r.first, err = parsePort(first); err != nil {
+		return r, err
+	}
+	if r.last, err = parsePort(last); err != nil {
+		return r, err
+	}
+	if r.first > r.last {
+		return r, errors.Errorf("invalid port range %q", text)
+	}
+
+	return r, nil
+}
+
+func parsePort(text string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.Errorf("invalid port %q", text)
+	}
+	return port, nil
+}
+
+// isPortAllowed returns whether the port is allowed for the scheme. Schemes without a list, when
+// there is no `*` list either, allow any port.
+func (p *Plugin) isPortAllowed(scheme string, port int) bool {
+	allowedPorts := p.getConfiguration().allowedPorts
+	ranges, ok := allowedPorts[scheme]
+	if !ok {
+		if ranges, ok = allowedPorts[anyScheme]; !ok {
+			return true
+		}
+	}
+
+	for _, r := range ranges {
+		if port >= r.first && port <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPorts flags the links with an explicit port which isn't allowed for their scheme. Links
+// without a port use the default port of their scheme, and are always allowed.
+func (p *Plugin) checkPorts(u *detectedURL, _ *model.Post) *violation {
+	if u.canonical.port == "" {
+		return nil
+	}
+
+	port, err := strconv.Atoi(u.canonical.port)
+	if err == nil && p.isPortAllowed(u.canonical.scheme, port) {
+		return nil
+	}
+
+	return &violation{
+		url:    u,
+		check:  checkPorts,
+		action: p.getConfiguration().PortAction,
+		reason: fmt.Sprintf("The link to `%s` uses the port %s, which isn't allowed for %s links.", u.canonical.String(), u.canonical.port, u.canonical.scheme),
+	}
+}
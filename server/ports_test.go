@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseAllowedPorts(t *testing.T) {
+	for _, text := range []string{
+		"https 443",
+		": 443",
+		"https: 0",
+		"https: 65536",
+		"https: web",
+		"http: 8100-8000",
+	} {
+		_, err := parseAllowedPorts(text)
+		assert.Error(t, err, text)
+	}
+
+	allowedPorts, err := parseAllowedPorts("HTTPS: 443, 8443\n\nhttp: 80, 8000-8100")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]portRange{
+		"https": {{first: 443, last: 443}, {first: 8443, last: 8443}},
+		"http":  {{first: 80, last: 80}, {first: 8000, last: 8100}},
+	}, allowedPorts)
+}
+
+func TestCheckPorts(t *testing.T) {
+	newPlugin := func(allowedPorts string) (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, false, "http,https,ftp", "", ""), nil, func(c *configuration) {
+			c.PortAction = actionReject
+			c.AllowedPorts = allowedPorts
+		})
+	}
+
+	var tests = []struct {
+		name         string
+		allowedPorts string
+		url          string
+		allowed      bool
+	}{
+		{name: "default port", allowedPorts: "https: 443, 8443", url: "https://example.com/", allowed: true},
+		{name: "allowed port", allowedPorts: "https: 443, 8443", url: "https://example.com:8443/", allowed: true},
+		{name: "denied port", allowedPorts: "https: 443, 8443", url: "https://example.com:4444/", allowed: false},
+		{name: "port of an IPv6 address", allowedPorts: "https: 443", url: "https://[2001:db8::1]:4444/", allowed: false},
+		{name: "port in a range", allowedPorts: "http: 8000-8100", url: "http://example.com:8080", allowed: true},
+		{name: "scheme without a list", allowedPorts: "https: 443", url: "http://example.com:4444", allowed: true},
+		{name: "scheme with the default list", allowedPorts: "https: 443\n*: 80, 443", url: "ftp://example.com:4444", allowed: false},
+		{name: "scheme with its own list", allowedPorts: "https: 443, 4444\n*: 80", url: "https://example.com:4444", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := newPlugin(test.allowedPorts)
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[link](" + test.url + ")"}, time.Now())
+			if test.allowed {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Len(t, reasons, 1)
+			}
+		})
+	}
+
+	t.Run("names the port", func(t *testing.T) {
+		p, _ := newPlugin("http: 80")
+		reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "http://example.com:4444/payload"}, time.Now())
+		assert.Equal(t, []string{"The link to `http://example.com:4444/payload` uses the port 4444, which isn't allowed for http links."}, reasons)
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	rateLimitKeyPrefix = "ratelimit_"
+	// rateLimitRetries is how many times a rate limit update is retried when another server of
+	// the cluster updated the same window concurrently.
+	rateLimitRetries = 5
+	// rateLimitSweepInterval is how often the windows of the users who stopped posting are
+	// removed from memory.
+	rateLimitSweepInterval = time.Minute
+)
+
+// slidingWindow holds the times, in milliseconds, at which links were posted within the window.
+type slidingWindow struct {
+	Timestamps []int64 `json:"timestamps"`
+}
+
+// prune removes the timestamps which are out of the window ending at now.
+func (w *slidingWindow) prune(now time.Time, window time.Duration) {
+	start := now.Add(-window).UnixMilli()
+	kept := w.Timestamps[:0]
+	for _, timestamp := range w.Timestamps {
+		if timestamp > start {
+			kept = append(kept, timestamp)
+		}
+	}
+	w.Timestamps = kept
+}
+
+// add records count links posted at now, unless it would exceed the limit. It returns whether the
+// links were recorded.
+func (w *slidingWindow) add(now time.Time, count, limit int) bool {
+	if len(w.Timestamps)+count > limit {
+		return false
+	}
+	for i := 0; i < count; i++ {
+		w.Timestamps = append(w.Timestamps, now.UnixMilli())
+	}
+	return true
+}
+
+// rateLimitWindows keeps in memory the last known window of each user, so the users who already
+// reached the limit are rejected without reading the KV store. The windows only miss the links
+// posted through the other servers of the cluster since their last update, so they never reject
+// a post the KV store would allow.
+type rateLimitWindows struct {
+	// lock synchronizes access to windows and sweptAt.
+	lock    sync.Mutex
+	windows map[string]*slidingWindow
+	sweptAt time.Time
+}
+
+// isLimited returns whether the window of key in memory has no room left for count links.
+func (r *rateLimitWindows) isLimited(key string, now time.Time, window time.Duration, count, limit int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if now.Sub(r.sweptAt) >= rateLimitSweepInterval {
+		for k, w := range r.windows {
+			if w.prune(now, window); len(w.Timestamps) == 0 {
+				delete(r.windows, k)
+			}
+		}
+		r.sweptAt = now
+	}
+
+	w, ok := r.windows[key]
+	if !ok {
+		return false
+	}
+	w.prune(now, window)
+	return len(w.Timestamps)+count > limit
+}
+
+// update replaces the window of key in memory with the window saved in the KV store.
+func (r *rateLimitWindows) update(key string, w slidingWindow) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.windows == nil {
+		r.windows = map[string]*slidingWindow{}
+	}
+	r.windows[key] = &slidingWindow{Timestamps: append([]int64{}, w.Timestamps...)}
+}
+
+// rateLimitKey returns the KV store key of the user's window. Keys are hashed to fit the KV store
+// key length limit when limiting per channel.
+func rateLimitKey(userID, channelID string) string {
+	sum := sha256.Sum256([]byte(userID + "_" + channelID))
+	return rateLimitKeyPrefix + hex.EncodeToString(sum[:16])
+}
+
+// checkRateLimit records the links of the post in the user's sliding window, and returns whether
+// the user exceeded the configured limit. The window is stored in the KV store and updated
+// atomically, so the limit holds across all the servers of a cluster. The copy of the window kept
+// in memory rejects the users who already reached the limit without reading the KV store.
+func (p *Plugin) checkRateLimit(detectedURLs []*detectedURL, post *model.Post, now time.Time) (bool, error) {
+	configuration := p.getConfiguration()
+	if configuration.RateLimitLinks <= 0 || configuration.RateLimitWindowSeconds <= 0 || len(detectedURLs) == 0 || !p.isRuleActive(ruleRateLimit, now) {
+		return false, nil
+	}
+
+	channelID := ""
+	if configuration.RateLimitPerChannel {
+		channelID = post.ChannelId
+	}
+	key := rateLimitKey(post.UserId, channelID)
+	window := time.Duration(configuration.RateLimitWindowSeconds) * time.Second
+	if p.rateLimitWindows.isLimited(key, now, window, len(detectedURLs), configuration.RateLimitLinks) {
+		return true, nil
+	}
+
+	for i := 0; i < rateLimitRetries; i++ {
+		oldValue, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return false, errors.Wrap(appErr, "failed to get the rate limit window")
+		}
+
+		var w slidingWindow
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &w); err != nil {
+				return false, errors.Wrap(err, "failed to unmarshal the rate limit window")
+			}
+		}
+
+		w.prune(now, window)
+		if !w.add(now, len(detectedURLs), configuration.RateLimitLinks) {
+			p.rateLimitWindows.update(key, w)
+			return true, nil
+		}
+
+		newValue, err := json.Marshal(w)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to marshal the rate limit window")
+		}
+
+		saved, appErr := p.API.KVSetWithOptions(key, newValue, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        oldValue,
+			ExpireInSeconds: int64(configuration.RateLimitWindowSeconds),
+		})
+		if appErr != nil {
+			return false, errors.Wrap(appErr, "failed to save the rate limit window")
+		}
+		if saved {
+			p.rateLimitWindows.update(key, w)
+			return false, nil
+		}
+	}
+
+	return false, errors.New("failed to save the rate limit window after concurrent updates")
+}
+
+// addedLinks returns the links of the edited post which weren't in the post before the edit, so
+// only the new links count toward the rate limit. Links are compared by canonical form, as they
+// are extracted from the messages, before any rewrite.
+func addedLinks(detectedURLs, oldURLs []*detectedURL) []*detectedURL {
+	old := map[string]int{}
+	for _, u := range oldURLs {
+		old[canonicalize(u.protocol, u.rawURL).String()]++
+	}
+
+	var added []*detectedURL
+	for _, u := range detectedURLs {
+		key := canonicalize(u.protocol, u.rawURL).String()
+		if old[key] > 0 {
+			old[key]--
+			continue
+		}
+		added = append(added, u)
+	}
+
+	return added
+}
+
+// enforceRateLimit rejects the post if the user exceeded the link rate limit, sending the
+// configured message to the user. For edits, only the links added by the edit are given.
+// Errors of the KV store are logged and the post is allowed.
+func (p *Plugin) enforceRateLimit(detectedURLs []*detectedURL, post *model.Post, isEdit bool) string {
+	limited, err := p.checkRateLimit(detectedURLs, post, time.Now())
+	if err != nil {
+		p.API.LogError("Failed to check the link rate limit", "user_id", post.UserId, "error", err.Error())
+		return ""
+	}
+	if !limited {
+		return ""
+	}
+
+	p.logDecision("Post rejected by the link rate limit", post, detectedURLs, []string{"Link rate limit exceeded"})
+	p.reportViolation(post, isEdit, nil, ruleRateLimit, actionReject, "Link rate limit exceeded")
+	p.sendWarning(post, p.getConfiguration().RateLimitMessage)
+	return "Link rate limit exceeded"
+}
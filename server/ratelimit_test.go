@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestCheckRateLimit(t *testing.T) {
+	newPlugin := func(perChannel bool) (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, true, "http,https", "http,https", ""), nil, func(c *configuration) {
+			c.RateLimitLinks = 3
+			c.RateLimitWindowSeconds = 60
+			c.RateLimitPerChannel = perChannel
+		})
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	check := func(p *Plugin, channelID, message string, at time.Time) bool {
+		post := &model.Post{UserId: "user1", ChannelId: channelID, Message: message}
+		limited, err := p.checkRateLimit(p.extractURLs(post), post, at)
+		require.NoError(t, err)
+		return limited
+	}
+
+	t.Run("limits the links posted within the window", func(t *testing.T) {
+		p, _ := newPlugin(false)
+
+		assert.False(t, check(p, "channel1", "https://a.com https://b.com", now))
+		assert.False(t, check(p, "channel1", "https://c.com", now.Add(time.Second)))
+		assert.True(t, check(p, "channel2", "https://d.com", now.Add(2*time.Second)))
+
+		// Links posted out of the window don't count anymore
+		assert.False(t, check(p, "channel2", "https://d.com", now.Add(61*time.Second)))
+	})
+
+	t.Run("limits each channel separately", func(t *testing.T) {
+		p, _ := newPlugin(true)
+
+		assert.False(t, check(p, "channel1", "https://a.com https://b.com https://c.com", now))
+		assert.False(t, check(p, "channel2", "https://d.com", now))
+		assert.True(t, check(p, "channel1", "https://d.com", now))
+	})
+
+	t.Run("ignores posts without links", func(t *testing.T) {
+		p, _ := newPlugin(false)
+		p.configuration.RateLimitLinks = 1
+
+		for i := 0; i < 3; i++ {
+			assert.False(t, check(p, "channel1", "no links here", now))
+		}
+	})
+
+	t.Run("rejects from the window in memory without the KV store", func(t *testing.T) {
+		p, mockAPI := newPlugin(false)
+
+		assert.False(t, check(p, "channel1", "https://a.com https://b.com https://c.com", now))
+		mockAPI.kv = nil
+		assert.True(t, check(p, "channel1", "https://d.com", now.Add(time.Second)))
+		assert.Empty(t, mockAPI.kv, "the KV store shouldn't be updated")
+
+		// Once the links leave the window in memory, the KV store is used again
+		assert.False(t, check(p, "channel1", "https://d.com", now.Add(61*time.Second)))
+		assert.NotEmpty(t, mockAPI.kv)
+	})
+
+	t.Run("is disabled by default", func(t *testing.T) {
+		p := newTestPlugin(t, true, "http,https", "http,https", "")
+
+		assert.False(t, check(p, "channel1", "https://a.com", now))
+	})
+}
+
+func TestRateLimitHook(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	p.configuration.RateLimitLinks = 2
+	p.configuration.RateLimitWindowSeconds = 60
+	p.configuration.RateLimitMessage = "Slow down."
+	mockAPI := &mockAPI{}
+	p.API = mockAPI
+
+	_, errMessage := p.MessageWillBePosted(nil, &model.Post{UserId: "user1", ChannelId: "channel1", Message: "https://a.com https://b.com"})
+	require.Empty(t, errMessage)
+	assert.Nil(t, mockAPI.sentEphemeralPost)
+
+	post, errMessage := p.MessageWillBePosted(nil, &model.Post{UserId: "user1", ChannelId: "channel1", Message: "https://c.com"})
+	assert.Nil(t, post)
+	assert.Equal(t, "Link rate limit exceeded", errMessage)
+	require.NotNil(t, mockAPI.sentEphemeralPost)
+	assert.Equal(t, "Slow down.", mockAPI.sentEphemeralPost.Message)
+
+	// Other users aren't limited
+	_, errMessage = p.MessageWillBePosted(nil, &model.Post{UserId: "user2", ChannelId: "channel1", Message: "https://c.com"})
+	assert.Empty(t, errMessage)
+}
+
+func TestRateLimitEdits(t *testing.T) {
+	p, _ := configureTestPlugin(t, newTestPlugin(t, true, "http,https", "http,https", ""), nil, func(c *configuration) {
+		c.RateLimitLinks = 2
+		c.RateLimitWindowSeconds = 60
+	})
+
+	oldPost := &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "no links yet"}
+	_, errMessage := p.MessageWillBePosted(nil, oldPost.Clone())
+	require.Empty(t, errMessage)
+
+	// Links kept from the previous version of the post don't count again
+	newPost := &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "https://a.com https://b.com"}
+	_, errMessage = p.MessageWillBeUpdated(nil, newPost.Clone(), oldPost)
+	require.Empty(t, errMessage)
+	_, errMessage = p.MessageWillBeUpdated(nil, &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "https://a.com https://b.com, edited"}, newPost)
+	require.Empty(t, errMessage)
+
+	post, errMessage := p.MessageWillBeUpdated(nil, &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "https://a.com https://b.com https://c.com"}, newPost)
+	assert.Nil(t, post)
+	assert.Equal(t, "Link rate limit exceeded", errMessage)
+}
+
+func TestAddedLinks(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https", "http,https", "")
+	urls := func(message string) []*detectedURL {
+		return p.extractURLs(&model.Post{Message: message})
+	}
+
+	tests := []struct {
+		name       string
+		oldMessage string
+		newMessage string
+		expected   int
+	}{
+		{name: "no previous links", oldMessage: "hello", newMessage: "https://a.com https://b.com", expected: 2},
+		{name: "unchanged links", oldMessage: "https://a.com", newMessage: "see https://a.com", expected: 0},
+		{name: "same canonical url", oldMessage: "https://A.com/", newMessage: "https://a.com/", expected: 0},
+		{name: "repeated link", oldMessage: "https://a.com", newMessage: "https://a.com https://a.com", expected: 1},
+		{name: "removed links", oldMessage: "https://a.com https://b.com", newMessage: "https://a.com", expected: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, addedLinks(urls(test.newMessage), urls(test.oldMessage)), test.expected)
+		})
+	}
+}
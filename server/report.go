@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// linkMetadata returns the scheme and host of each URL, without the path, the query or any other
+// content of the message.
+func linkMetadata(detectedURLs []*detectedURL) []string {
+	var metadata []string
+	for _, u := range detectedURLs {
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		if u.canonical.host == "" {
+			metadata = append(metadata, u.canonical.scheme+":")
+		} else {
+			metadata = append(metadata, u.canonical.scheme+"://"+u.canonical.host)
+		}
+	}
+
+	return metadata
+}
+
+// logDecision records a decision of the link filter about the post in the server logs. When the
+// privacy mode applies to the post, the record only contains the scheme and host of the links,
+// never the reasons or URLs, which could reveal the content of the message.
+func (p *Plugin) logDecision(message string, post *model.Post, detectedURLs []*detectedURL, reasons []string) {
+	keyValuePairs := []interface{}{
+		"user_id", post.UserId,
+		"channel_id", post.ChannelId,
+	}
+
+	if p.isPrivacyModeApplied(post) {
+		keyValuePairs = append(keyValuePairs, "links", strings.Join(linkMetadata(detectedURLs), " "))
+	} else {
+		keyValuePairs = append(keyValuePairs,
+			"reasons", strings.Join(reasons, " "),
+			"urls", strings.Join(canonicalURLs(detectedURLs), " "),
+		)
+	}
+
+	p.API.LogInfo(message, keyValuePairs...)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const (
+	checkReputation = "reputation"
+
+	// Names of the reputation checkers, as shown in the logs.
+	checkerDeniedHosts       = "denied_hosts"
+	checkerReputationFeeds   = "reputation_feeds"
+	checkerURLPatterns       = "url_patterns"
+	checkerReputationService = "reputation_service"
+
+	// feedRefresh is how often the reputation feeds are downloaded again.
+	feedRefresh = time.Hour
+	// feedRetry is how long to wait before downloading a feed again after a failure.
+	feedRetry = time.Minute
+	// defaultFeedTimeout is how long a reputation feed is given to download.
+	defaultFeedTimeout = time.Minute
+	// maxFeedSize limits the size of a reputation feed.
+	maxFeedSize = 10 * 1024 * 1024
+)
+
+// hostSet is a set of canonical hosts.
+type hostSet map[string]struct{}
+
+// parseHostSet parses a list of hosts, one per line or separated by commas. Lines can be URLs, or
+// in the hosts file format, e.g. `0.0.0.0 evil.com`, and text after a `#` is a comment.
+func parseHostSet(text string) hostSet {
+	hosts := hostSet{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, maxFeedSize)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		for _, entry := range util.TrimString(strings.Split(line, ",")) {
+			fields := strings.Fields(entry)
+			host := fields[len(fields)-1]
+			if strings.Contains(host, "://") {
+				if parsed, err := url.Parse(host); err == nil {
+					host = parsed.Hostname()
+				}
+			}
+			if canonicalHost, _, _ := canonicalizeHost(host); canonicalHost != "" {
+				hosts[canonicalHost] = struct{}{}
+			}
+		}
+	}
+
+	return hosts
+}
+
+// find returns the host, or the parent domain of the host, found in the set, or an empty string.
+func (s hostSet) find(host string) string {
+	for host != "" {
+		if _, ok := s[host]; ok {
+			return host
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return ""
+}
+
+// deniedHostsChecker denies the links to a static list of hosts and their subdomains.
+type deniedHostsChecker struct {
+	action string
+	hosts  hostSet
+}
+
+func (c *deniedHostsChecker) Name() string {
+	return checkerDeniedHosts
+}
+
+func (c *deniedHostsChecker) Check(_ context.Context, u *detectedURL) Verdict {
+	host := c.hosts.find(u.canonical.host)
+	if host == "" {
+		return Verdict{Action: actionOff}
+	}
+
+	return Verdict{
+		Action: c.action,
+		Reason: fmt.Sprintf("The link to `%s` points to the denied host `%s`.", u.canonical.String(), host),
+	}
+}
+
+// feed is the last download of a reputation feed.
+type feed struct {
+	hosts hostSet
+	// err is the error of the last download, if it failed.
+	err       error
+	nextFetch time.Time
+}
+
+// feedChecker denies the links to the hosts listed by reputation feeds. The feeds are downloaded
+// in the background every feedRefresh, so the hooks never wait for a download. The last downloaded
+// version of a feed is used while it can't be downloaded again.
+type feedChecker struct {
+	action string
+	urls   []string
+	client *http.Client
+	// timeout is how long each feed is given to download. defaultFeedTimeout is used if it's zero.
+	timeout time.Duration
+
+	// lock synchronizes access to feeds.
+	lock  sync.RWMutex
+	feeds map[string]*feed
+
+	// runLock synchronizes access to cancel and done.
+	runLock sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (c *feedChecker) Name() string {
+	return checkerReputationFeeds
+}
+
+func (c *feedChecker) Check(_ context.Context, u *detectedURL) Verdict {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var failure error
+	for _, feedURL := range c.urls {
+		f := c.feeds[feedURL]
+		if f == nil {
+			failure = errors.Errorf("the feed %s hasn't been downloaded yet", feedURL)
+			continue
+		}
+		if f.hosts == nil {
+			failure = f.err
+			continue
+		}
+
+		if f.hosts.find(u.canonical.host) != "" {
+			return Verdict{
+				Action: c.action,
+				Reason: fmt.Sprintf("The link to `%s` is listed by the reputation feed `%s`.", u.canonical.String(), feedURL),
+			}
+		}
+	}
+
+	if failure != nil {
+		return Verdict{Err: failure}
+	}
+	return Verdict{Action: actionOff}
+}
+
+// start downloads the feeds in the background until stop is called. The feeds downloaded by the
+// checker of the previous configuration are kept until they're due, so a configuration change
+// doesn't download them again.
+func (c *feedChecker) start(previous *feedChecker) {
+	if previous != nil {
+		previous.lock.RLock()
+		c.lock.Lock()
+		for _, feedURL := range c.urls {
+			if f := previous.feeds[feedURL]; f != nil {
+				c.feeds[feedURL] = f
+			}
+		}
+		c.lock.Unlock()
+		previous.lock.RUnlock()
+	}
+
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if c.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel, c.done = cancel, make(chan struct{})
+	go c.run(ctx, c.done)
+}
+
+// stop stops the downloads, and waits for the current one to finish.
+func (c *feedChecker) stop() {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if c.cancel == nil {
+		return
+	}
+
+	c.cancel()
+	<-c.done
+	c.cancel, c.done = nil, nil
+}
+
+func (c *feedChecker) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		timer := time.NewTimer(c.refreshFeeds(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshFeeds downloads the feeds which are due, and returns how long to wait until the next one
+// is due. A feed which can't be downloaded keeps its previous version, and is retried after
+// feedRetry.
+func (c *feedChecker) refreshFeeds(ctx context.Context) time.Duration {
+	wait := feedRefresh
+	for _, feedURL := range c.urls {
+		c.lock.RLock()
+		f := c.feeds[feedURL]
+		c.lock.RUnlock()
+
+		if f == nil || !time.Now().Before(f.nextFetch) {
+			hosts, err := c.fetchFeed(ctx, feedURL)
+			if ctx.Err() != nil {
+				return wait
+			}
+			refreshed := &feed{hosts: hosts, nextFetch: time.Now().Add(feedRefresh)}
+			if err != nil {
+				refreshed = &feed{err: err, nextFetch: time.Now().Add(feedRetry)}
+				if f != nil {
+					refreshed.hosts = f.hosts
+				}
+			}
+
+			c.lock.Lock()
+			c.feeds[feedURL] = refreshed
+			c.lock.Unlock()
+			f = refreshed
+		}
+
+		if until := time.Until(f.nextFetch); until < wait {
+			wait = until
+		}
+	}
+
+	return wait
+}
+
+func (c *feedChecker) fetchFeed(ctx context.Context, feedURL string) (hostSet, error) {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = defaultFeedTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the feed request")
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download the feed %s", feedURL)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to download the feed %s: %s", feedURL, response.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxFeedSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the feed %s", feedURL)
+	}
+
+	return parseHostSet(string(data)), nil
+}
+
+// patternChecker denies the links whose canonical URL matches one of the regular expressions.
+type patternChecker struct {
+	action   string
+	patterns []*regexp.Regexp
+}
+
+// parseURLPatterns parses one regular expression per line.
+func parseURLPatterns(text string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, line := range util.TrimString(strings.Split(text, "\n")) {
+		pattern, err := regexp.Compile(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid URL pattern %q", line)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+func (c *patternChecker) Name() string {
+	return checkerURLPatterns
+}
+
+func (c *patternChecker) Check(_ context.Context, u *detectedURL) Verdict {
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(u.canonical.String()) {
+			return Verdict{
+				Action: c.action,
+				Reason: fmt.Sprintf("The link to `%s` matches the denied pattern `%s`.", u.canonical.String(), pattern),
+			}
+		}
+	}
+
+	return Verdict{Action: actionOff}
+}
+
+// feedCheckerOf returns the reputation feeds checker of the configuration, or nil.
+func feedCheckerOf(configuration *configuration) *feedChecker {
+	if configuration == nil {
+		return nil
+	}
+	for _, checker := range configuration.reputationCheckers {
+		if c, ok := checker.(*feedChecker); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// startReputationFeeds starts downloading the reputation feeds of the configuration, and stops the
+// downloads of the previous configuration.
+func (p *Plugin) startReputationFeeds(previous, current *configuration) {
+	previousChecker, currentChecker := feedCheckerOf(previous), feedCheckerOf(current)
+	if currentChecker != nil {
+		currentChecker.start(previousChecker)
+	}
+	if previousChecker != nil && previousChecker != currentChecker {
+		previousChecker.stop()
+	}
+}
+
+// stopReputationFeeds stops downloading the reputation feeds when the plugin is deactivated.
+func (p *Plugin) stopReputationFeeds() {
+	if checker := feedCheckerOf(p.getConfiguration()); checker != nil {
+		checker.stop()
+	}
+}
+
+// newReputationCheckers returns the reputation checkers enabled by the configuration, in the order
+// they're run: the local lists first, then the remote ones.
+func newReputationCheckers(configuration *configuration) ([]URLChecker, error) {
+	action := configuration.ReputationAction
+	client := &http.Client{}
+	cacheTTL := time.Duration(configuration.CheckerCacheTTL) * time.Second
+
+	var checkers []URLChecker
+	if hosts := parseHostSet(configuration.DeniedHosts); len(hosts) > 0 {
+		checkers = append(checkers, &deniedHostsChecker{action: action, hosts: hosts})
+	}
+
+	if feedURLs := util.TrimString(strings.Split(configuration.ReputationFeeds, "\n")); len(feedURLs) > 0 {
+		checkers = append(checkers, &feedChecker{action: action, urls: feedURLs, client: client, feeds: map[string]*feed{}})
+	}
+
+	patterns, err := parseURLPatterns(configuration.URLPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) > 0 {
+		checkers = append(checkers, &patternChecker{action: action, patterns: patterns})
+	}
+
+	if serviceURL := strings.TrimSpace(configuration.ReputationServiceURL); serviceURL != "" {
+		checkers = append(checkers, newCachingChecker(&serviceChecker{
+			action:     action,
+			serviceURL: serviceURL,
+			secret:     configuration.ReputationServiceSecret,
+			timeout:    time.Duration(configuration.ReputationServiceTimeout) * time.Millisecond,
+			fallback:   configuration.ReputationServiceFallback,
+			client:     client,
+		}, cacheTTL))
+	}
+
+	return checkers, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseHostSet(t *testing.T) {
+	hosts := parseHostSet("# Malware hosts\n0.0.0.0 evil.com\nhttps://Phishing.example/login\nbad.org, worse.org # inline comment\n\n")
+	assert.Equal(t, hostSet{"evil.com": {}, "phishing.example": {}, "bad.org": {}, "worse.org": {}}, hosts)
+
+	assert.Equal(t, "evil.com", hosts.find("www.evil.com"))
+	assert.Equal(t, "evil.com", hosts.find("evil.com"))
+	assert.Empty(t, hosts.find("notevil.com"))
+	assert.Empty(t, hosts.find("com"))
+}
+
+func TestParseURLPatterns(t *testing.T) {
+	_, err := parseURLPatterns("^https://example\\.com/\n(")
+	assert.Error(t, err)
+
+	patterns, err := parseURLPatterns("^https://example\\.com/\n\n/wp-admin/")
+	require.NoError(t, err)
+	assert.Len(t, patterns, 2)
+}
+
+func TestReputationCheckers(t *testing.T) {
+	var feedRequests int32
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&feedRequests, 1)
+		_, _ = w.Write([]byte("# Feed\nmalware.example\n"))
+	}))
+	defer feed.Close()
+	service := newMockVerdictService(t, "", map[string]serviceVerdictResponse{"https://classified.example/": {Verdict: verdictDeny, Reason: "Phishing kit"}})
+
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.ReputationAction = actionReject
+	p.configuration.DeniedHosts = "evil.com, bad.org"
+	p.configuration.ReputationFeeds = feed.URL
+	p.configuration.URLPatterns = `/wp-admin/`
+	p.configuration.ReputationServiceURL = service.URL
+	p.configuration.CheckerCacheTTL = 60
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+	p.startReputationFeeds(nil, p.configuration)
+	defer p.stopReputationFeeds()
+	require.Eventually(t, func() bool {
+		return feedCheckerOf(p.configuration).Check(context.Background(), &detectedURL{canonical: canonicalize("https", "https://example.com")}).Err == nil
+	}, time.Second, 10*time.Millisecond, "the feed should be downloaded in the background")
+
+	var tests = []struct {
+		name           string
+		url            string
+		expectedReason string
+	}{
+		{name: "allowed", url: "https://example.com"},
+		{name: "denied host", url: "https://cdn.evil.com/file", expectedReason: "The link to `https://cdn.evil.com/file` points to the denied host `evil.com`."},
+		{name: "feed", url: "http://malware.example/payload", expectedReason: "The link to `http://malware.example/payload` is listed by the reputation feed `" + feed.URL + "`."},
+		{name: "pattern", url: "https://blog.example.com/wp-admin/setup.php", expectedReason: "The link to `https://blog.example.com/wp-admin/setup.php` matches the denied pattern `/wp-admin/`."},
+		{name: "service", url: "https://classified.example/", expectedReason: "The link to `https://classified.example/` is denied by the reputation service: Phishing kit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[link](" + test.url + ")"}, time.Now())
+			if test.expectedReason == "" {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Equal(t, []string{test.expectedReason}, reasons)
+			}
+		})
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&feedRequests), "the feed should only be downloaded once")
+
+	// The feed isn't downloaded again after a configuration change
+	previous := p.configuration
+	p.configuration = previous.Clone()
+	p.configuration.DeniedHosts = "evil.com"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.startReputationFeeds(previous, p.configuration)
+	reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[link](http://malware.example/payload)"}, time.Now())
+	assert.Len(t, reasons, 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&feedRequests))
+	assert.Nil(t, feedCheckerOf(previous).cancel, "the downloads of the previous configuration should be stopped")
+}
+
+func TestFeedCheckerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	checker := &feedChecker{action: actionReject, urls: []string{server.URL}, client: &http.Client{}, timeout: 50 * time.Millisecond, feeds: map[string]*feed{}}
+
+	start := time.Now()
+	assert.Equal(t, feedRetry, checker.refreshFeeds(context.Background()).Round(time.Second))
+	assert.Less(t, time.Since(start), time.Second, "a feed server which never answers shouldn't stall the downloads")
+	assert.Error(t, checker.feeds[server.URL].err)
+}
+
+func TestFeedCheckerUnavailable(t *testing.T) {
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !available {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("malware.example"))
+	}))
+	defer server.Close()
+
+	checker := &feedChecker{action: actionReject, urls: []string{server.URL}, client: &http.Client{}, feeds: map[string]*feed{}}
+	u := &detectedURL{canonical: canonicalize("https", "https://malware.example")}
+
+	assert.Error(t, checker.Check(context.Background(), u).Err, "the feed isn't downloaded by the hooks")
+	assert.Equal(t, feedRetry, checker.refreshFeeds(context.Background()).Round(time.Second))
+	assert.Error(t, checker.Check(context.Background(), u).Err)
+
+	available = true
+	checker.feeds[server.URL].nextFetch = time.Time{}
+	assert.Equal(t, feedRefresh, checker.refreshFeeds(context.Background()).Round(time.Second))
+	assert.Equal(t, actionReject, checker.Check(context.Background(), u).Action)
+
+	available = false
+	checker.feeds[server.URL].nextFetch = time.Time{}
+	checker.refreshFeeds(context.Background())
+	assert.Equal(t, actionReject, checker.Check(context.Background(), u).Action, "the last version of the feed should be used")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Modes for rewriting allowed external links to go through a safe-link service.
+const (
+	safeLinkModeOff      = "off"
+	safeLinkModeTemplate = "template"
+
+	// Placeholders replaced in the safe-link template
+	safeLinkPlaceholderURL        = "{{url}}"
+	safeLinkPlaceholderURLEncoded = "{{urlencoded}}"
+
+	// OriginalURLsProp is the post prop holding the original URLs of the rewritten links, for auditing.
+	OriginalURLsProp = "link_filter_original_urls"
+)
+
+// safeLinkRewriter rewrites an allowed external URL to its safe-link form.
+type safeLinkRewriter func(rawURL string) string
+
+// parseSafeLinkTemplate validates the safe-link template and returns its host.
+func parseSafeLinkTemplate(template string) (string, error) {
+	if !strings.Contains(template, safeLinkPlaceholderURL) && !strings.Contains(template, safeLinkPlaceholderURLEncoded) {
+		return "", errors.Errorf("safe-link template must contain %s or %s", safeLinkPlaceholderURLEncoded, safeLinkPlaceholderURL)
+	}
+
+	parsed, err := url.Parse(strings.NewReplacer(safeLinkPlaceholderURL, "", safeLinkPlaceholderURLEncoded, "").Replace(template))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse safe-link template")
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", errors.New("safe-link template must be an absolute http or https URL")
+	}
+
+	return strings.ToLower(parsed.Hostname()), nil
+}
+
+// templateSafeLink fills the configured safe-link template with the URL.
+func (p *Plugin) templateSafeLink(rawURL string) string {
+	return strings.NewReplacer(
+		safeLinkPlaceholderURLEncoded, url.QueryEscape(rawURL),
+		safeLinkPlaceholderURL, rawURL,
+	).Replace(p.getConfiguration().SafeLinkTemplate)
+}
+
+// safeLinkRewriter returns the rewriter for the configured safe-link mode, or nil if allowed
+// links shouldn't be rewritten.
+func (p *Plugin) safeLinkRewriter() safeLinkRewriter {
+	switch p.getConfiguration().SafeLinkMode {
+	case safeLinkModeTemplate:
+		return p.templateSafeLink
+	case safeLinkModeInterstitial:
+		return p.interstitialSafeLink
+	default:
+		return nil
+	}
+}
+
+// rewriteSafeLinks rewrites the allowed external links of the post, both embedded and plain, to
+// go through the safe-link service. Links to excluded hosts are left untouched. The original URLs
+// are kept in the OriginalURLsProp post prop, and each rewrite is recorded in the audit log. It must
+// only be called once the post has passed the filter.
+func (p *Plugin) rewriteSafeLinks(detectedURLs []*detectedURL, post *model.Post, isEdit bool) {
+	rewrite := p.safeLinkRewriter()
+	if rewrite == nil {
+		return
+	}
+
+	var originalURLs []interface{}
+	if existing, ok := post.GetProp(OriginalURLsProp).([]interface{}); ok {
+		originalURLs = existing
+	}
+
+	for _, u := range detectedURLs {
+		if u.rewritten {
+			continue
+		}
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		if !p.isSafeLinkCandidate(u.canonical) {
+			continue
+		}
+
+		original := u.rawURL
+		rewritten := rewrite(original)
+		if rewritten == original {
+			continue
+		}
+		p.auditDecision(post, isEdit, u, ruleSafeLinks, actionRewrite, "Link rewritten to go through the safe-link service")
+		p.updateDetectedURL(post, detectedURLs, u, rewritten)
+		u.rewritten = true
+		originalURLs = append(originalURLs, map[string]interface{}{
+			"original":  original,
+			"rewritten": rewritten,
+		})
+	}
+
+	if len(originalURLs) > 0 {
+		post.AddProp(OriginalURLsProp, originalURLs)
+	}
+}
+
+// isSafeLink returns whether the URL was rewritten to go through the safe-link service or the
+// interstitial page.
+func (p *Plugin) isSafeLink(u *detectedURL) bool {
+	switch p.getConfiguration().SafeLinkMode {
+	case safeLinkModeTemplate:
+		return u.canonical != nil && u.canonical.host == p.getConfiguration().safeLinkTemplateHost
+	case safeLinkModeInterstitial:
+		return strings.HasPrefix(u.rawURL, p.interstitialURL()+"?")
+	default:
+		return false
+	}
+}
+
+// isSafeLinkCandidate returns whether the URL is an external http(s) link which should go through
+// the safe-link service.
+func (p *Plugin) isSafeLinkCandidate(c *canonicalURL) bool {
+	if (c.scheme != "http" && c.scheme != "https") || c.host == "" {
+		return false
+	}
+
+	for _, excluded := range p.getConfiguration().safeLinkExcludedHosts {
+		if c.host == excluded || strings.HasSuffix(c.host, "."+excluded) {
+			return false
+		}
+	}
+
+	return true
+}
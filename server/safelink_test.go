@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestRewriteSafeLinks(t *testing.T) {
+	p := newTestPlugin(t, true, "http,https,mailto", "http,https,mailto", "tel")
+	p.configuration.SafeLinkMode = safeLinkModeTemplate
+	p.configuration.SafeLinkTemplate = "https://safelink.example.com/?u={{urlencoded}}"
+	p.configuration.SafeLinkExcludedHosts = "intranet.example.com"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	var tests = []struct {
+		name                 string
+		message              string
+		expectedMessage      string
+		expectedOriginalURLs []string
+	}{
+		{
+			name:                 "rewrites plain links",
+			message:              "See https://github.com/mattermost for details",
+			expectedMessage:      "See https://safelink.example.com/?u=https%3A%2F%2Fgithub.com%2Fmattermost for details",
+			expectedOriginalURLs: []string{"https://github.com/mattermost"},
+		},
+		{
+			name:                 "rewrites embedded links",
+			message:              "See [the repo](http://github.com/mattermost?tab=readme)",
+			expectedMessage:      "See [the repo](https://safelink.example.com/?u=http%3A%2F%2Fgithub.com%2Fmattermost%3Ftab%3Dreadme)",
+			expectedOriginalURLs: []string{"http://github.com/mattermost?tab=readme"},
+		},
+		{
+			name:                 "rewrites the destination of links showing their own URL",
+			message:              "[https://x.com/a](https://x.com/a)",
+			expectedMessage:      "[https://safelink.example.com/?u=https%3A%2F%2Fx.com%2Fa](https://safelink.example.com/?u=https%3A%2F%2Fx.com%2Fa)",
+			expectedOriginalURLs: []string{"https://x.com/a", "https://x.com/a"},
+		},
+		{
+			name:                 "rewrites plain links shown earlier as link text",
+			message:              "[see https://x.com/a](https://y.com) https://x.com/a",
+			expectedMessage:      "[see https://safelink.example.com/?u=https%3A%2F%2Fx.com%2Fa](https://safelink.example.com/?u=https%3A%2F%2Fy.com) https://safelink.example.com/?u=https%3A%2F%2Fx.com%2Fa",
+			expectedOriginalURLs: []string{"https://y.com", "https://x.com/a", "https://x.com/a"},
+		},
+		{
+			name:            "doesn't rewrite excluded hosts and their subdomains",
+			message:         "https://intranet.example.com/wiki [docs](https://docs.intranet.example.com)",
+			expectedMessage: "https://intranet.example.com/wiki [docs](https://docs.intranet.example.com)",
+		},
+		{
+			name:            "doesn't rewrite links to the safe-link service",
+			message:         "https://safelink.example.com/?u=https%3A%2F%2Fgithub.com",
+			expectedMessage: "https://safelink.example.com/?u=https%3A%2F%2Fgithub.com",
+		},
+		{
+			name:            "doesn't rewrite non-web links",
+			message:         "[mail](mailto:plugin@example.com) tel://1234",
+			expectedMessage: "[mail](mailto:plugin@example.com) tel(1234)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			post, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: test.message})
+			require.Empty(t, errMessage)
+			assert.Equal(t, test.expectedMessage, post.Message)
+
+			if len(test.expectedOriginalURLs) == 0 {
+				assert.Nil(t, post.GetProp(OriginalURLsProp))
+				return
+			}
+			originalURLs, ok := post.GetProp(OriginalURLsProp).([]interface{})
+			require.True(t, ok)
+			require.Len(t, originalURLs, len(test.expectedOriginalURLs))
+			for i, expected := range test.expectedOriginalURLs {
+				assert.Equal(t, expected, originalURLs[i].(map[string]interface{})["original"])
+			}
+		})
+	}
+
+	t.Run("rewrites plain links shown earlier as link text when the link text is checked", func(t *testing.T) {
+		p2 := newTestPlugin(t, true, "http,https", "http,https", "")
+		p2.configuration.SafeLinkMode = safeLinkModeTemplate
+		p2.configuration.SafeLinkTemplate = p.configuration.SafeLinkTemplate
+		p2.configuration.MismatchedLinkTextAction = actionWarn
+		require.NoError(t, p2.initConfiguration(p2.configuration))
+		p2.API = &mockAPI{}
+
+		post, errMessage := p2.MessageWillBePosted(nil, &model.Post{Message: "[see https://x.com/a](https://y.com) https://x.com/a"})
+		require.Empty(t, errMessage)
+		assert.Equal(t, "[see https://x.com/a](https://safelink.example.com/?u=https%3A%2F%2Fy.com) https://safelink.example.com/?u=https%3A%2F%2Fx.com%2Fa", post.Message)
+	})
+
+	t.Run("keeps the original URLs of previous rewrites on edit", func(t *testing.T) {
+		post, errMessage := p.MessageWillBePosted(nil, &model.Post{Message: "https://github.com"})
+		require.Empty(t, errMessage)
+
+		post.Message += " https://gitlab.com"
+		post, errMessage = p.MessageWillBeUpdated(nil, post, nil)
+		require.Empty(t, errMessage)
+
+		assert.Equal(t, "https://safelink.example.com/?u=https%3A%2F%2Fgithub.com https://safelink.example.com/?u=https%3A%2F%2Fgitlab.com", post.Message)
+		assert.Len(t, post.GetProp(OriginalURLsProp), 2)
+	})
+}
+
+func TestParseSafeLinkTemplate(t *testing.T) {
+	host, err := parseSafeLinkTemplate("https://SafeLink.example.com/?u={{urlencoded}}")
+	require.NoError(t, err)
+	assert.Equal(t, "safelink.example.com", host)
+
+	_, err = parseSafeLinkTemplate("https://safelink.example.com/")
+	assert.Error(t, err)
+
+	_, err = parseSafeLinkTemplate("/relative?u={{url}}")
+	assert.Error(t, err)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	monitorModeKey = "monitormode"
+
+	// monitorModeRefresh is how often the monitor mode is read from the KV store, so it's shared
+	// by all the servers of a cluster.
+	monitorModeRefresh = 30 * time.Second
+
+	// rejectionWindowMinutes is the length of the window the rejection rate is measured over.
+	rejectionWindowMinutes = 10
+)
+
+// monitorMode is stored in the KV store while the filter only logs its decisions instead of
+// enforcing them.
+type monitorMode struct {
+	Reason string `json:"reason"`
+	Since  int64  `json:"since"`
+	// RelaxProtocols is set when the allowed protocols lists are the cause of the monitor mode, so
+	// only the dangerous schemes are still rejected.
+	RelaxProtocols bool `json:"relax_protocols"`
+}
+
+// rejectionBucket records the users who posted links within a minute, and whether any of their
+// posts was rejected.
+type rejectionBucket struct {
+	minute int64
+	users  map[string]bool
+}
+
+// rejectionTracker measures the rate of users whose posts with links are rejected on this server,
+// over the last rejectionWindowMinutes minutes. Users are counted once however many posts they
+// make, so a single user can't switch to the monitor mode.
+type rejectionTracker struct {
+	lock    sync.Mutex
+	buckets [rejectionWindowMinutes]rejectionBucket
+}
+
+// record counts a post of the user filtered at now, and returns the number of users who posted
+// links within the window, and how many of them had a post rejected.
+func (t *rejectionTracker) record(now time.Time, userID string, rejected bool) (posters, rejections int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	minute := now.Unix() / 60
+	bucket := &t.buckets[minute%rejectionWindowMinutes]
+	if bucket.minute != minute || bucket.users == nil {
+		*bucket = rejectionBucket{minute: minute, users: map[string]bool{}}
+	}
+	bucket.users[userID] = bucket.users[userID] || rejected
+
+	users := map[string]bool{}
+	for _, b := range t.buckets {
+		if b.minute > minute-rejectionWindowMinutes {
+			for user, userRejected := range b.users {
+				users[user] = users[user] || userRejected
+			}
+		}
+	}
+	for _, userRejected := range users {
+		if userRejected {
+			rejections++
+		}
+	}
+	return len(users), rejections
+}
+
+func (t *rejectionTracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.buckets = [rejectionWindowMinutes]rejectionBucket{}
+}
+
+// configurationProblems returns the problems introduced by the configuration change, which would
+// reject most links. Problems already present in the previous configuration are considered
+// intended.
+func configurationProblems(before, after *configuration) []string {
+	if before == nil {
+		return nil
+	}
+
+	var problems []string
+	for _, check := range []struct {
+		problem string
+		applies func(c *configuration) bool
+	}{
+		{
+			problem: "The allowed protocols list for links is empty, all the embedded links are rejected.",
+			applies: func(c *configuration) bool { return strings.TrimSpace(c.AllowedProtocolListLink) == "" },
+		},
+		{
+			problem: "The allowed protocols list for plain text is empty while plain links are rejected, all the plain text links are rejected.",
+			applies: func(c *configuration) bool {
+				return c.RejectPlainLinks && strings.TrimSpace(c.AllowedProtocolListPlainText) == ""
+			},
+		},
+		{
+			problem: "The allowed protocols list for links doesn't allow https, most embedded links are rejected.",
+			applies: func(c *configuration) bool {
+				policy, err := newProtocolPolicy(policyDefault, c.AllowedProtocolListLink, c.AllowedProtocolListPlainText, c.RejectPlainLinks)
+				return err == nil && policy.allowedLink != nil && !policy.allowsLink("https")
+			},
+		},
+	} {
+		if check.applies(after) && !check.applies(before) {
+			problems = append(problems, check.problem)
+		}
+	}
+
+	return problems
+}
+
+// checkConfigurationSafety switches to the monitor mode if the configuration change would reject
+// most links.
+func (p *Plugin) checkConfigurationSafety(before, after *configuration) {
+	if !after.SafetyGuard {
+		return
+	}
+
+	if problems := configurationProblems(before, after); len(problems) > 0 {
+		p.enterMonitorMode("The configuration was changed in a way that rejects most links:\n"+bulletList(problems), true, time.Now())
+	}
+}
+
+// recordFilterOutcome counts the post towards the rejection rate, and switches to the monitor
+// mode if the rate exceeds the configured maximum. Posts without links aren't counted.
+func (p *Plugin) recordFilterOutcome(detectedURLs []*detectedURL, post *model.Post, rejected bool) {
+	configuration := p.getConfiguration()
+	if !configuration.SafetyGuard || configuration.SafetyGuardMaxRejectionRate <= 0 || len(detectedURLs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	posters, rejections := p.rejections.record(now, post.UserId, rejected)
+	if posters < configuration.SafetyGuardMinPosts || rejections*100 <= posters*configuration.SafetyGuardMaxRejectionRate {
+		return
+	}
+
+	p.enterMonitorMode(fmt.Sprintf(
+		"%d of the last %d users posting links had posts rejected within %d minutes, above the maximum rejection rate of %d%%.",
+		rejections, posters, rejectionWindowMinutes, configuration.SafetyGuardMaxRejectionRate,
+	), false, now)
+}
+
+// enterMonitorMode switches all the servers to the monitor mode, and alerts the system admins if
+// the mode wasn't already on. relaxProtocols is set when the allowed protocols lists caused the
+// switch.
+func (p *Plugin) enterMonitorMode(reason string, relaxProtocols bool, now time.Time) {
+	mode := &monitorMode{Reason: reason, Since: now.UnixMilli(), RelaxProtocols: relaxProtocols}
+	data, err := json.Marshal(mode)
+	if err != nil {
+		p.API.LogError("Failed to marshal the monitor mode", "error", err.Error())
+		return
+	}
+
+	saved, appErr := p.API.KVSetWithOptions(monitorModeKey, data, model.PluginKVSetOptions{Atomic: true})
+	if appErr != nil {
+		p.API.LogError("Failed to save the monitor mode", "error", appErr.Error())
+		return
+	}
+	if !saved {
+		// Another server switched to the monitor mode first
+		p.refreshMonitorMode(now)
+		return
+	}
+
+	p.setMonitorMode(mode, now)
+	p.API.LogWarn("The link filter switched to the monitor mode", "reason", reason)
+	go p.alertAdmins(fmt.Sprintf(
+		"The link filter switched to the monitor mode: links are only checked against the allowed protocols and the dangerous schemes, and the other decisions are only logged.\n%s\n\nCheck the configuration, then run `/%s monitor off` to enforce it again.",
+		reason, commandTrigger,
+	))
+}
+
+// exitMonitorMode switches all the servers back to enforcing the configuration.
+func (p *Plugin) exitMonitorMode(now time.Time) error {
+	if appErr := p.API.KVDelete(monitorModeKey); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete the monitor mode")
+	}
+	p.setMonitorMode(nil, now)
+	p.rejections.reset()
+
+	return nil
+}
+
+// getMonitorMode returns the monitor mode, or nil if the configuration is enforced. It's read from
+// the KV store at most every monitorModeRefresh.
+func (p *Plugin) getMonitorMode(now time.Time) *monitorMode {
+	p.monitorModeLock.Lock()
+	mode, checkedAt := p.monitorMode, p.monitorModeCheckedAt
+	p.monitorModeLock.Unlock()
+
+	if now.Sub(checkedAt) < monitorModeRefresh {
+		return mode
+	}
+
+	return p.refreshMonitorMode(now)
+}
+
+func (p *Plugin) refreshMonitorMode(now time.Time) *monitorMode {
+	value, appErr := p.API.KVGet(monitorModeKey)
+	if appErr != nil {
+		p.API.LogError("Failed to get the monitor mode", "error", appErr.Error())
+		return nil
+	}
+
+	var mode *monitorMode
+	if value != nil {
+		mode = &monitorMode{}
+		if err := json.Unmarshal(value, mode); err != nil {
+			p.API.LogError("Failed to unmarshal the monitor mode", "error", err.Error())
+			return nil
+		}
+	}
+
+	p.setMonitorMode(mode, now)
+	return mode
+}
+
+func (p *Plugin) setMonitorMode(mode *monitorMode, now time.Time) {
+	p.monitorModeLock.Lock()
+	defer p.monitorModeLock.Unlock()
+
+	p.monitorMode = mode
+	p.monitorModeCheckedAt = now
+}
+
+// filterMonitoredPost filters the post while the monitor mode is on. The dangerous schemes and,
+// unless they caused the monitor mode, the allowed protocols lists are still enforced. The other
+// checks only log what they would do with the post.
+func (p *Plugin) filterMonitoredPost(mode *monitorMode, post *model.Post, isEdit bool, now time.Time) (*model.Post, string) {
+	detectedURLs := p.extractURLs(post)
+	invalidURLProtocols := p.getInvalidProtocols(detectedURLs, post, now)
+	if mode.RelaxProtocols {
+		invalidURLProtocols = slices.DeleteFunc(invalidURLProtocols, func(scheme string) bool { return !isDangerousScheme(scheme) })
+	}
+	if len(invalidURLProtocols) > 0 {
+		return nil, p.rejectInvalidProtocols(detectedURLs, post, isEdit, invalidURLProtocols)
+	}
+
+	p.monitorPost(post, now)
+	post.Message = p.rewriteLinks(detectedURLs, post)
+	p.reportRewrittenProtocols(detectedURLs, post, isEdit)
+	p.auditAllowedPost(detectedURLs, post, isEdit)
+
+	return post, ""
+}
+
+// monitorPost logs what the filter would do with the post, without changing it.
+func (p *Plugin) monitorPost(post *model.Post, now time.Time) {
+	if reasons := p.evaluatePost(post, now); len(reasons) > 0 {
+		p.logDecision("Post allowed by the monitor mode of the link filter", post, p.extractURLs(post), reasons)
+	}
+}
+
+// executeMonitorCommand shows whether the monitor mode is on, or switches it on or off.
+func (p *Plugin) executeMonitorCommand(_ *model.CommandArgs, parameters []string) string {
+	now := time.Now()
+	if len(parameters) == 0 {
+		mode := p.refreshMonitorMode(now)
+		if mode == nil {
+			return "The monitor mode is off, the configuration is enforced."
+		}
+		return fmt.Sprintf("The monitor mode is on since %s, only the allowed protocols and the dangerous schemes are enforced:\n%s", time.UnixMilli(mode.Since).UTC().Format(time.RFC1123), mode.Reason)
+	}
+
+	switch parameters[0] {
+	case "on":
+		p.enterMonitorMode("Switched on manually.", false, now)
+		return "The monitor mode is on, only the allowed protocols and the dangerous schemes are enforced."
+	case "off":
+		if err := p.exitMonitorMode(now); err != nil {
+			return fmt.Sprintf("Failed to switch the monitor mode off: %s", err.Error())
+		}
+		return "The monitor mode is off, the configuration is enforced."
+	default:
+		return fmt.Sprintf("Unknown monitor mode `%s`, expected `on` or `off`.", parameters[0])
+	}
+}
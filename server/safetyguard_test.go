@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestConfigurationProblems(t *testing.T) {
+	valid := &configuration{AllowedProtocolListLink: "http,https", AllowedProtocolListPlainText: "https", RejectPlainLinks: true}
+
+	var tests = []struct {
+		name             string
+		before           *configuration
+		after            *configuration
+		expectedProblems int
+	}{
+		{
+			name:   "valid configuration",
+			before: valid,
+			after:  &configuration{AllowedProtocolListLink: "https", AllowedProtocolListPlainText: "https", RejectPlainLinks: true},
+		},
+		{
+			name:             "emptied link allowlist",
+			before:           valid,
+			after:            &configuration{AllowedProtocolListLink: " ", AllowedProtocolListPlainText: "https", RejectPlainLinks: true},
+			expectedProblems: 1,
+		},
+		{
+			name:             "emptied plain text allowlist",
+			before:           valid,
+			after:            &configuration{AllowedProtocolListLink: "http,https", RejectPlainLinks: true},
+			expectedProblems: 1,
+		},
+		{
+			name:             "https removed from the link allowlist",
+			before:           valid,
+			after:            &configuration{AllowedProtocolListLink: "htps", AllowedProtocolListPlainText: "https", RejectPlainLinks: true},
+			expectedProblems: 1,
+		},
+		{
+			name:   "allowlists already empty",
+			before: &configuration{RejectPlainLinks: true},
+			after:  &configuration{RejectPlainLinks: true, RateLimitLinks: 10},
+		},
+		{
+			name:  "first configuration",
+			after: &configuration{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, configurationProblems(test.before, test.after), test.expectedProblems)
+		})
+	}
+}
+
+func TestSafetyGuard(t *testing.T) {
+	newPlugin := func() (*Plugin, *mockAPI) {
+		return configureTestPlugin(t, newTestPlugin(t, false, "https", "", ""), &mockAPI{admins: map[string]bool{"admin": true}}, func(c *configuration) {
+			c.SafetyGuard = true
+			c.SafetyGuardMaxRejectionRate = 50
+			c.SafetyGuardMinPosts = 4
+		})
+	}
+	post := func(userID, message string) *model.Post {
+		return &model.Post{UserId: userID, ChannelId: "channel1", Message: message}
+	}
+
+	t.Run("switches to the monitor mode when the rejection rate spikes", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+		p.configuration.MaxEmbeddedLinks = 1
+
+		for _, userID := range []string{"user1", "user2"} {
+			_, errMessage := p.MessageWillBePosted(nil, post(userID, "[link](http://example.com)"))
+			assert.NotEmpty(t, errMessage)
+		}
+		_, errMessage := p.MessageWillBePosted(nil, post("user3", "[link](https://example.com)"))
+		assert.Empty(t, errMessage)
+		_, errMessage = p.MessageWillBePosted(nil, post("user4", "no link"))
+		assert.Empty(t, errMessage)
+		assert.Nil(t, p.getMonitorMode(time.Now()))
+
+		_, errMessage = p.MessageWillBePosted(nil, post("user4", "[link](http://example.com)"))
+		assert.NotEmpty(t, errMessage)
+		require.NotNil(t, p.getMonitorMode(time.Now()))
+		assert.Contains(t, p.getMonitorMode(time.Now()).Reason, "3 of the last 4 users posting links had posts rejected")
+
+		// The other checks only log their decisions
+		mockAPI.infoLogs = nil
+		in := post("user1", "[link](https://example.com) [link](https://example.org)")
+		out, errMessage := p.MessageWillBePosted(nil, in)
+		assert.Empty(t, errMessage)
+		assert.Equal(t, in, out)
+		require.Len(t, mockAPI.infoLogs, 1)
+		assert.Equal(t, "Post allowed by the monitor mode of the link filter", mockAPI.infoLogs[0].message)
+
+		// The allowed protocols lists are still enforced
+		_, errMessage = p.MessageWillBePosted(nil, post("user1", "[link](http://example.com)"))
+		assert.Equal(t, "Schemes not allowed: http", errMessage)
+		_, errMessage = p.MessageWillBeUpdated(nil, post("user1", "[link](http://example.com)"), nil)
+		assert.Equal(t, "Schemes not allowed: http", errMessage)
+
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter monitor off", UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "The monitor mode is off, the configuration is enforced.", response.Text)
+		_, errMessage = p.MessageWillBePosted(nil, post("user1", "[link](https://example.com) [link](https://example.org)"))
+		assert.NotEmpty(t, errMessage)
+	})
+
+	t.Run("doesn't switch to the monitor mode for a single user", func(t *testing.T) {
+		p, _ := newPlugin()
+
+		for i := 0; i < 10; i++ {
+			_, errMessage := p.MessageWillBePosted(nil, post("spammer", "[link](http://example.com)"))
+			assert.NotEmpty(t, errMessage)
+		}
+		for _, userID := range []string{"user1", "user2", "user3"} {
+			_, errMessage := p.MessageWillBePosted(nil, post(userID, "[link](https://example.com)"))
+			assert.Empty(t, errMessage)
+		}
+		assert.Nil(t, p.getMonitorMode(time.Now()))
+	})
+
+	t.Run("switches to the monitor mode when the configuration empties an allowlist", func(t *testing.T) {
+		p, mockAPI := newPlugin()
+		mockAPI.savedConfig = map[string]interface{}{"allowedprotocollistlink": "https", "safetyguard": true}
+		require.NoError(t, p.OnConfigurationChange())
+		assert.Nil(t, p.getMonitorMode(time.Now()))
+
+		mockAPI.savedConfig = map[string]interface{}{"allowedprotocollistlink": "", "safetyguard": true}
+		require.NoError(t, p.OnConfigurationChange())
+		mode := p.getMonitorMode(time.Now())
+		require.NotNil(t, mode)
+		assert.Contains(t, mode.Reason, "The allowed protocols list for links is empty")
+
+		// Only the dangerous schemes are still rejected
+		_, errMessage := p.MessageWillBePosted(nil, post("user1", "[link](https://example.com)"))
+		assert.Empty(t, errMessage)
+		_, errMessage = p.MessageWillBePosted(nil, post("user1", "[link](javascript:alert(1))"))
+		assert.Equal(t, "Schemes not allowed: javascript", errMessage)
+	})
+
+	t.Run("shares the monitor mode between servers", func(t *testing.T) {
+		p, _ := newPlugin()
+		other := &Plugin{}
+		other.API = p.API
+		now := time.Now()
+		assert.Nil(t, other.getMonitorMode(now))
+
+		p.enterMonitorMode("testing", false, now)
+		assert.Nil(t, other.getMonitorMode(now))
+		mode := other.getMonitorMode(now.Add(monitorModeRefresh))
+		require.NotNil(t, mode)
+		assert.Equal(t, "testing", mode.Reason)
+	})
+}
+
+func TestAlertAdmins(t *testing.T) {
+	p := newTestPlugin(t, false, "https", "", "")
+	mockAPI := &mockAPI{users: map[string]*model.User{
+		"admin": {Id: "admin", Roles: "system_user system_admin"},
+		"user1": {Id: "user1", Roles: "system_user"},
+	}}
+	p.API = mockAPI
+	p.botUserID = "bot"
+
+	p.alertAdmins("The link filter switched to the monitor mode")
+	require.Len(t, mockAPI.createdPosts, 1)
+	assert.Equal(t, "bot", mockAPI.createdPosts[0].UserId)
+	assert.Equal(t, model.GetDMNameFromIds("bot", "admin"), mockAPI.createdPosts[0].ChannelId)
+}
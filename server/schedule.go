@@ -0,0 +1,202 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embedded so the schedule time zones can be loaded on servers without a time zone database
+	_ "time/tzdata"
+
+	"github.com/pkg/errors"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+// Names of the rules which can be given a schedule, in addition to the protocol policies.
+const (
+	ruleLinkLimits = "link_limits"
+	ruleRateLimit  = "rate_limit"
+)
+
+// scheduledRules are the rules which can be given a schedule. A rule with schedules is only
+// applied while one of its schedules is active.
+var scheduledRules = []string{
+	policyNewUser,
+	policyDirectMessage,
+	policyOffHours,
+	checkHomoglyph,
+	checkMismatchedLinkText,
+	checkURLRules,
+	checkIPAddresses,
+	checkPorts,
+	checkFileExtensions,
+	checkShortLinks,
+	checkReputation,
+	ruleLinkLimits,
+	ruleRateLimit,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// schedule is a set of days of the week and a time range within those days, in a time zone.
+// Time ranges ending before they start span midnight, e.g. 17:00-09:00, and belong to the day
+// they start on.
+type schedule struct {
+	days     [7]bool
+	start    int // minutes since midnight
+	end      int // minutes since midnight, up to 24:00
+	location *time.Location
+}
+
+// parseSchedules parses one schedule per line, in the form `<rule>: <days> <start>-<end> [time zone]`,
+// e.g. `off_hours: Mon-Fri 17:00-09:00 Europe/Berlin`. It returns the schedules by rule name.
+func parseSchedules(text string) (map[string][]*schedule, error) {
+	schedules := map[string][]*schedule{}
+	for _, line := range util.TrimString(strings.Split(text, "\n")) {
+		rule, spec, found := strings.Cut(line, ":")
+		if !found {
+			return nil, errors.Errorf("invalid schedule %q: expected <rule>: <days> <start>-<end> [time zone]", line)
+		}
+		rule = strings.TrimSpace(rule)
+		if !slices.Contains(scheduledRules, rule) {
+			return nil, errors.Errorf("invalid schedule %q: unknown rule %q, expected one of %s", line, rule, strings.Join(scheduledRules, ", "))
+		}
+
+		s, err := parseSchedule(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q", line)
+		}
+		schedules[rule] = append(schedules[rule], s)
+	}
+
+	return schedules, nil
+}
+
+// parseSchedule parses a schedule in the form `<days> <start>-<end> [time zone]`. Days are
+// separated by commas and can be ranges, e.g. `Mon-Fri` or `Sat,Sun`, or `*` for every day. The
+// time zone defaults to UTC.
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.New("expected <days> <start>-<end> [time zone]")
+	}
+
+	s := &schedule{location: time.UTC}
+	if err := s.parseDays(fields[0]); err != nil {
+		return nil, err
+	}
+
+	start, end, found := strings.Cut(fields[1], "-")
+	if !found {
+		return nil, errors.Errorf("invalid time range %q", fields[1])
+	}
+	var err error
+	if s.start, err = parseTimeOfDay(start); err != nil {
+		return nil, err
+	}
+	if s.end, err = parseTimeOfDay(end); err != nil {
+		return nil, err
+	}
+	if s.start == s.end || s.start == 24*60 {
+		return nil, errors.Errorf("invalid time range %q", fields[1])
+	}
+
+	if len(fields) == 3 {
+		if s.location, err = time.LoadLocation(fields[2]); err != nil {
+			return nil, errors.Wrapf(err, "invalid time zone %q", fields[2])
+		}
+	}
+
+	return s, nil
+}
+
+func (s *schedule) parseDays(days string) error {
+	if days == "*" {
+		for i := range s.days {
+			s.days[i] = true
+		}
+		return nil
+	}
+
+	for _, days := range strings.Split(days, ",") {
+		first, last, isRange := strings.Cut(days, "-")
+		firstDay, ok := weekdayNames[strings.ToLower(first)]
+		if !ok {
+			return errors.Errorf("invalid day %q", first)
+		}
+		lastDay := firstDay
+		if isRange {
+			if lastDay, ok = weekdayNames[strings.ToLower(last)]; !ok {
+				return errors.Errorf("invalid day %q", last)
+			}
+		}
+
+		// Ranges can wrap around the end of the week, e.g. Sat-Mon
+		for day := firstDay; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == lastDay {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseTimeOfDay parses a time in the form HH:MM and returns the number of minutes since midnight.
+func parseTimeOfDay(text string) (int, error) {
+	hours, minutes, found := strings.Cut(text, ":")
+	if !found {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", text)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", text)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", text)
+	}
+
+	return h*60 + m, nil
+}
+
+// isActive returns whether the schedule is active at the given time.
+func (s *schedule) isActive(now time.Time) bool {
+	local := now.In(s.location)
+	minutes := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if s.start < s.end {
+		return s.days[day] && minutes >= s.start && minutes < s.end
+	}
+
+	previousDay := (day + 6) % 7
+	return (s.days[day] && minutes >= s.start) || (s.days[previousDay] && minutes < s.end)
+}
+
+// isRuleActive returns whether the rule is applied at the given time. Rules without schedules are
+// always applied.
+func (p *Plugin) isRuleActive(rule string, now time.Time) bool {
+	schedules := p.getConfiguration().schedules[rule]
+	if len(schedules) == 0 {
+		return true
+	}
+
+	for _, s := range schedules {
+		if s.isActive(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseSchedules(t *testing.T) {
+	for _, text := range []string{
+		"off_hours Mon-Fri 17:00-09:00",
+		"unknown: Mon-Fri 17:00-09:00",
+		"off_hours: Mon-Fri",
+		"off_hours: Someday 09:00-17:00",
+		"off_hours: Mon-Fri 09:00",
+		"off_hours: Mon-Fri 9-17",
+		"off_hours: Mon-Fri 09:00-24:30",
+		"off_hours: Mon-Fri 09:00-09:00",
+		"off_hours: Mon-Fri 09:00-17:00 Nowhere/City",
+	} {
+		_, err := parseSchedules(text)
+		assert.Error(t, err, text)
+	}
+
+	schedules, err := parseSchedules("off_hours: Mon-Fri 17:00-09:00 Europe/Berlin\n\noff_hours: Sat,Sun 00:00-24:00 Europe/Berlin\nhomoglyph: * 09:00-17:00")
+	require.NoError(t, err)
+	assert.Len(t, schedules[policyOffHours], 2)
+	assert.Len(t, schedules[checkHomoglyph], 1)
+}
+
+func TestScheduleIsActive(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name     string
+		schedule string
+		at       time.Time
+		expected bool
+	}{
+		{
+			name:     "within the range",
+			schedule: "Mon-Fri 09:00-17:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 14, 9, 0, 0, 0, berlin), // Wednesday
+			expected: true,
+		},
+		{
+			name:     "at the end of the range",
+			schedule: "Mon-Fri 09:00-17:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 14, 17, 0, 0, 0, berlin),
+			expected: false,
+		},
+		{
+			name:     "in another time zone",
+			schedule: "Mon-Fri 09:00-17:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 14, 7, 30, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "on another day",
+			schedule: "Mon-Fri 09:00-17:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), // Saturday
+			expected: false,
+		},
+		{
+			name:     "overnight range before midnight",
+			schedule: "Mon-Fri 17:00-09:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 16, 23, 0, 0, 0, berlin), // Friday
+			expected: true,
+		},
+		{
+			name:     "overnight range after midnight",
+			schedule: "Mon-Fri 17:00-09:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 17, 8, 0, 0, 0, berlin), // Saturday, started on Friday
+			expected: true,
+		},
+		{
+			name:     "overnight range after midnight of a day out of the schedule",
+			schedule: "Mon-Fri 17:00-09:00 Europe/Berlin",
+			at:       time.Date(2026, 10, 12, 8, 0, 0, 0, berlin), // Monday, would start on Sunday
+			expected: false,
+		},
+		{
+			name:     "whole day in a range wrapping around the week",
+			schedule: "Sat-Mon 00:00-24:00",
+			at:       time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC), // Sunday
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := parseSchedule(test.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, s.isActive(test.at))
+		})
+	}
+}
+
+func TestScheduledRules(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	businessHours := time.Date(2026, 10, 14, 10, 0, 0, 0, berlin)
+	night := time.Date(2026, 10, 14, 22, 0, 0, 0, berlin)
+
+	p := newTestPlugin(t, false, "http,https,ftp", "", "")
+	p.configuration.OffHoursAllowedProtocolListLink = "https"
+	p.configuration.MismatchedLinkTextAction = actionReject
+	p.configuration.Schedules = "off_hours: Mon-Fri 17:00-09:00 Europe/Berlin\nmismatched_link_text: Mon-Fri 09:00-17:00 Europe/Berlin"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{admins: map[string]bool{"admin": true}}
+
+	post := func(message string) *model.Post {
+		return &model.Post{UserId: "user1", ChannelId: "channel1", Message: message}
+	}
+
+	t.Run("applies the off-hours policy while its schedule is active", func(t *testing.T) {
+		assert.Equal(t, policyDefault, p.protocolPolicyForPost(post(""), businessHours).name)
+		assert.Equal(t, policyOffHours, p.protocolPolicyForPost(post(""), night).name)
+
+		assert.Empty(t, p.evaluatePost(post("[file](ftp://example.com/file)"), businessHours))
+		assert.Equal(t, []string{"Schemes not allowed: ftp"}, p.evaluatePost(post("[file](ftp://example.com/file)"), night))
+	})
+
+	t.Run("runs scheduled checks while their schedule is active", func(t *testing.T) {
+		assert.Len(t, p.evaluatePost(post("[https://bank.com](https://evil.com)"), businessHours), 1)
+		assert.Empty(t, p.evaluatePost(post("[https://bank.com](https://evil.com)"), night))
+	})
+
+	t.Run("simulates a time with the test command", func(t *testing.T) {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{
+			Command:   "/linkfilter test --at 2026-10-14T22:00:00+02:00 [file](ftp://example.com/file)",
+			UserId:    "admin",
+			ChannelId: "channel1",
+		})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Evaluated at 2026-10-14T22:00:00+02:00 with the `off_hours` policy.\nThe links of the message would be rejected or defanged:\n* Schemes not allowed: ftp", response.Text)
+
+		response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{
+			Command:   "/linkfilter test --at 2026-10-14T10:00:00+02:00 [file](ftp://example.com/file)",
+			UserId:    "admin",
+			ChannelId: "channel1",
+		})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Evaluated at 2026-10-14T10:00:00+02:00 with the `default` policy.\nThe message would be allowed.", response.Text)
+
+		response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/linkfilter test --at tonight hello", UserId: "admin"})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "Invalid time `tonight`")
+	})
+}
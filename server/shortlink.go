@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const (
+	checkShortLinks = "short_links"
+
+	shortLinkTableKey = "shortlinks"
+
+	// shortLinkTableRefresh is how often the short link table is read from the KV store, so the
+	// changes made on another server of the cluster are seen.
+	shortLinkTableRefresh = 30 * time.Second
+	// shortLinkTableRetries is how many times a change of the table is retried when another
+	// server changed it concurrently.
+	shortLinkTableRetries = 5
+
+	// maxShortLinkHops limits how many short links pointing to other short links are resolved.
+	maxShortLinkHops = 5
+	// maxShortLinksPerPost limits how many short links of a post are resolved when the shorteners
+	// are asked over HTTP. The other short links are considered unresolved.
+	maxShortLinksPerPost = 10
+	// defaultShortLinkResolverTimeout is how long the short links of a post are resolved for when
+	// no timeout is configured.
+	defaultShortLinkResolverTimeout = 2 * time.Second
+	// shortLinkCacheDuration is how long the destinations returned by the shorteners are cached.
+	shortLinkCacheDuration = time.Hour
+)
+
+// shortLinkResolver returns the destination of short links.
+type shortLinkResolver interface {
+	// resolve returns the destination of the short link, or an empty string if it's unknown. It
+	// gives up when the context is done.
+	resolve(ctx context.Context, shortLink *canonicalURL) (string, error)
+}
+
+// shortLinkKey returns the key of a short link in the short link table: its host and path,
+// without the scheme and the query.
+func shortLinkKey(shortLink *canonicalURL) string {
+	return shortLink.host + strings.TrimSuffix(shortLink.path, "/")
+}
+
+// shortLinkTableResolver resolves the short links listed in the table maintained by the system
+// admins with /linkfilter shortlink. It doesn't need any network access.
+type shortLinkTableResolver struct {
+	p *Plugin
+}
+
+func (r *shortLinkTableResolver) resolve(_ context.Context, shortLink *canonicalURL) (string, error) {
+	table, err := r.p.getShortLinkTable(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return table[shortLinkKey(shortLink)], nil
+}
+
+// cachedDestination is a destination returned by a shortener.
+type cachedDestination struct {
+	destination string
+	expiresAt   time.Time
+}
+
+// httpShortLinkResolver asks the shortener for the destination of the short link, reading the
+// redirect without following it.
+type httpShortLinkResolver struct {
+	client *http.Client
+
+	// cacheLock synchronizes access to cache.
+	cacheLock sync.Mutex
+	cache     map[string]cachedDestination
+}
+
+func newHTTPShortLinkResolver(timeout time.Duration) *httpShortLinkResolver {
+	if timeout <= 0 {
+		timeout = defaultShortLinkResolverTimeout
+	}
+	return &httpShortLinkResolver{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cache: map[string]cachedDestination{},
+	}
+}
+
+func (r *httpShortLinkResolver) resolve(ctx context.Context, shortLink *canonicalURL) (string, error) {
+	key := shortLink.String()
+	now := time.Now()
+
+	r.cacheLock.Lock()
+	cached, ok := r.cache[key]
+	r.cacheLock.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.destination, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create the request")
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request the short link")
+	}
+	response.Body.Close()
+
+	var destination string
+	if response.StatusCode >= 300 && response.StatusCode < 400 {
+		location, err := response.Location()
+		if err != nil {
+			return "", errors.Wrap(err, "invalid redirect")
+		}
+		destination = location.String()
+	}
+
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	r.cache[key] = cachedDestination{destination: destination, expiresAt: now.Add(shortLinkCacheDuration)}
+	for k, c := range r.cache {
+		if now.After(c.expiresAt) {
+			delete(r.cache, k)
+		}
+	}
+
+	return destination, nil
+}
+
+// parseShortenerHosts parses a comma separated list of the hosts of link shorteners.
+func parseShortenerHosts(list string) []string {
+	var hosts []string
+	for _, host := range util.TrimString(strings.Split(list, ",")) {
+		canonicalHost, _, _ := canonicalizeHost(host)
+		hosts = append(hosts, canonicalHost)
+	}
+	return hosts
+}
+
+// isShortLink returns whether the URL is on the host of a link shortener, or one of its subdomains.
+func (p *Plugin) isShortLink(u *canonicalURL) bool {
+	for _, host := range p.getConfiguration().shortenerHosts {
+		if u.host == host || strings.HasSuffix(u.host, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDestination returns the canonical form of a short link destination, or nil if it isn't an
+// http(s) URL.
+func parseDestination(destination string) *canonicalURL {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil
+	}
+	return canonicalize(parsed.Scheme, destination)
+}
+
+// resolveShortLinks replaces the canonical form of the short links with the canonical form of
+// their destination, so the policies apply to the destination. Short links which can't be
+// resolved are marked, to be handled by checkShortLinks. It runs after cleanupLinks, before the
+// policies are evaluated.
+//
+// The short links of the post are resolved in parallel, within the configured timeout for the
+// whole post. When the shorteners are asked over HTTP, only the first maxShortLinksPerPost short
+// links are resolved.
+func (p *Plugin) resolveShortLinks(detectedURLs []*detectedURL) {
+	configuration := p.getConfiguration()
+	if len(configuration.shortenerHosts) == 0 {
+		return
+	}
+
+	timeout := time.Duration(configuration.ShortLinkResolverTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultShortLinkResolverTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	shortLinks := 0
+	for _, u := range detectedURLs {
+		if u.rewritten {
+			continue
+		}
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		if !p.isShortLink(u.canonical) {
+			continue
+		}
+
+		shortLinks++
+		if len(configuration.shortLinkResolvers) > 0 && shortLinks > maxShortLinksPerPost {
+			u.unresolvedShortLink = true
+			continue
+		}
+
+		wg.Add(1)
+		go func(u *detectedURL) {
+			defer wg.Done()
+
+			destination := p.resolveShortLink(ctx, u.canonical)
+			if destination == nil {
+				u.unresolvedShortLink = true
+				return
+			}
+			u.shortLink = u.canonical
+			u.canonical = destination
+		}(u)
+	}
+	wg.Wait()
+}
+
+// resolveShortLink returns the final destination of the short link, following the short links
+// pointing to other short links, or nil if it can't be resolved.
+func (p *Plugin) resolveShortLink(ctx context.Context, shortLink *canonicalURL) *canonicalURL {
+	current := shortLink
+	for i := 0; i < maxShortLinkHops; i++ {
+		destination := p.lookupShortLink(ctx, current)
+		if destination == nil {
+			return nil
+		}
+		if !p.isShortLink(destination) {
+			return destination
+		}
+		current = destination
+	}
+
+	return nil
+}
+
+// lookupShortLink returns the destination of the short link given by the first resolver which
+// knows it, or nil. The short link table is always looked up first.
+func (p *Plugin) lookupShortLink(ctx context.Context, shortLink *canonicalURL) *canonicalURL {
+	resolvers := append([]shortLinkResolver{&shortLinkTableResolver{p: p}}, p.getConfiguration().shortLinkResolvers...)
+	for _, resolver := range resolvers {
+		destination, err := resolver.resolve(ctx, shortLink)
+		if err != nil {
+			p.API.LogWarn("Failed to resolve a short link", "host", shortLink.host, "error", err.Error())
+			continue
+		}
+		if target := parseDestination(destination); target != nil {
+			return target
+		}
+	}
+
+	return nil
+}
+
+// checkShortLinks flags the short links which couldn't be resolved to their destination.
+func (p *Plugin) checkShortLinks(u *detectedURL, _ *model.Post) *violation {
+	if !u.unresolvedShortLink {
+		return nil
+	}
+
+	return &violation{
+		url:    u,
+		check:  checkShortLinks,
+		action: p.getConfiguration().UnresolvedShortLinkAction,
+		reason: fmt.Sprintf("The short link `%s` hides its destination.", u.canonical.String()),
+	}
+}
+
+// getShortLinkTable returns the short link table, mapping the keys of short links to their
+// destination. It's read from the KV store at most every shortLinkTableRefresh.
+func (p *Plugin) getShortLinkTable(now time.Time) (map[string]string, error) {
+	p.shortLinkTableLock.Lock()
+	defer p.shortLinkTableLock.Unlock()
+
+	if p.shortLinkTable != nil && now.Sub(p.shortLinkTableCheckedAt) < shortLinkTableRefresh {
+		return p.shortLinkTable, nil
+	}
+
+	table, _, err := p.readShortLinkTable()
+	if err != nil {
+		return nil, err
+	}
+	p.shortLinkTable, p.shortLinkTableCheckedAt = table, now
+
+	return table, nil
+}
+
+// readShortLinkTable reads the short link table from the KV store, with its stored value.
+func (p *Plugin) readShortLinkTable() (map[string]string, []byte, error) {
+	value, appErr := p.API.KVGet(shortLinkTableKey)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to get the short link table")
+	}
+
+	table := map[string]string{}
+	if value != nil {
+		if err := json.Unmarshal(value, &table); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to unmarshal the short link table")
+		}
+	}
+
+	return table, value, nil
+}
+
+// updateShortLinkTable applies the change to the short link table. The table is replaced
+// atomically, so concurrent changes from other servers aren't lost.
+func (p *Plugin) updateShortLinkTable(change func(table map[string]string)) error {
+	for i := 0; i < shortLinkTableRetries; i++ {
+		table, oldValue, err := p.readShortLinkTable()
+		if err != nil {
+			return err
+		}
+		change(table)
+
+		data, err := json.Marshal(table)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the short link table")
+		}
+		saved, appErr := p.API.KVSetWithOptions(shortLinkTableKey, data, model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to save the short link table")
+		}
+		if !saved {
+			continue
+		}
+
+		p.shortLinkTableLock.Lock()
+		p.shortLinkTable, p.shortLinkTableCheckedAt = table, time.Now()
+		p.shortLinkTableLock.Unlock()
+		return nil
+	}
+
+	return errors.New("failed to save the short link table after concurrent updates")
+}
+
+// parseShortLink returns the canonical form of a short link given to /linkfilter shortlink, with
+// or without its scheme.
+func parseShortLink(text string) *canonicalURL {
+	if !strings.Contains(text, "://") {
+		text = "https://" + text
+	}
+	return parseDestination(text)
+}
+
+// executeShortLinkCommand lists the short link table, or adds or removes a short link.
+func (p *Plugin) executeShortLinkCommand(_ *model.CommandArgs, parameters []string) string {
+	if len(parameters) == 0 {
+		table, _, err := p.readShortLinkTable()
+		if err != nil {
+			return fmt.Sprintf("Failed to get the short links: %s", err.Error())
+		}
+		if len(table) == 0 {
+			return "No short link has been added yet."
+		}
+
+		keys := make([]string, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var lines []string
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("`%s` → `%s`", key, table[key]))
+		}
+		return "Short links:\n" + bulletList(lines)
+	}
+
+	switch {
+	case parameters[0] == "add" && len(parameters) == 3:
+		shortLink := parseShortLink(parameters[1])
+		if shortLink == nil {
+			return fmt.Sprintf("Invalid short link `%s`.", parameters[1])
+		}
+		destination := parseDestination(parameters[2])
+		if destination == nil {
+			return fmt.Sprintf("Invalid destination `%s`, expected an http or https URL.", parameters[2])
+		}
+
+		key := shortLinkKey(shortLink)
+		if err := p.updateShortLinkTable(func(table map[string]string) { table[key] = destination.String() }); err != nil {
+			return fmt.Sprintf("Failed to add the short link: %s", err.Error())
+		}
+		return fmt.Sprintf("The short link `%s` now resolves to `%s`.", key, destination.String())
+	case parameters[0] == "remove" && len(parameters) == 2:
+		shortLink := parseShortLink(parameters[1])
+		if shortLink == nil {
+			return fmt.Sprintf("Invalid short link `%s`.", parameters[1])
+		}
+
+		key := shortLinkKey(shortLink)
+		if err := p.updateShortLinkTable(func(table map[string]string) { delete(table, key) }); err != nil {
+			return fmt.Sprintf("Failed to remove the short link: %s", err.Error())
+		}
+		return fmt.Sprintf("The short link `%s` has been removed.", key)
+	default:
+		return "Usage: `/" + commandTrigger + " shortlink [add <short link> <destination>|remove <short link>]`"
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestShortLinkCommand(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.API = &mockAPI{}
+
+	assert.Equal(t, "No short link has been added yet.", p.executeShortLinkCommand(nil, nil))
+	assert.Equal(t, "Invalid destination `javascript:alert(1)`, expected an http or https URL.", p.executeShortLinkCommand(nil, []string{"add", "bit.ly/abc", "javascript:alert(1)"}))
+	assert.Equal(t, "The short link `bit.ly/abc` now resolves to `https://example.com/page`.", p.executeShortLinkCommand(nil, []string{"add", "https://BIT.ly/abc/", "https://example.com/page"}))
+	assert.Equal(t, "The short link `t.co/xyz` now resolves to `https://example.org`.", p.executeShortLinkCommand(nil, []string{"add", "t.co/xyz", "https://example.org"}))
+	assert.Equal(t, "Short links:\n* `bit.ly/abc` → `https://example.com/page`\n* `t.co/xyz` → `https://example.org`", p.executeShortLinkCommand(nil, nil))
+
+	assert.Equal(t, "The short link `t.co/xyz` has been removed.", p.executeShortLinkCommand(nil, []string{"remove", "t.co/xyz"}))
+	assert.Equal(t, "Short links:\n* `bit.ly/abc` → `https://example.com/page`", p.executeShortLinkCommand(nil, nil))
+	assert.Contains(t, p.executeShortLinkCommand(nil, []string{"add", "bit.ly/abc"}), "Usage:")
+}
+
+func TestResolveShortLinks(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.ShortenerHosts = "bit.ly, t.co"
+	p.configuration.URLRules = "deny evil.com"
+	p.configuration.URLRuleAction = actionReject
+	p.configuration.UnresolvedShortLinkAction = actionReject
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	require.NoError(t, p.updateShortLinkTable(func(table map[string]string) {
+		table["bit.ly/good"] = "https://example.com/page"
+		table["bit.ly/evil"] = "https://evil.com/login"
+		table["t.co/chain"] = "https://bit.ly/evil"
+		table["t.co/loop"] = "https://t.co/loop"
+	}))
+
+	var tests = []struct {
+		name           string
+		url            string
+		expectedReason string
+	}{
+		{name: "not a short link", url: "https://example.com"},
+		{name: "resolved to an allowed link", url: "https://bit.ly/good?utm_source=x"},
+		{name: "resolved to a denied link", url: "https://bit.ly/evil", expectedReason: "The link to `https://evil.com/login` is denied by the rule `deny evil.com`."},
+		{name: "short link to a short link", url: "https://t.co/chain", expectedReason: "The link to `https://evil.com/login` is denied by the rule `deny evil.com`."},
+		{name: "unknown short link", url: "https://bit.ly/unknown", expectedReason: "The short link `https://bit.ly/unknown` hides its destination."},
+		{name: "loop", url: "https://t.co/loop", expectedReason: "The short link `https://t.co/loop` hides its destination."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[link](" + test.url + ")"}, time.Now())
+			if test.expectedReason == "" {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Equal(t, []string{test.expectedReason}, reasons)
+			}
+		})
+	}
+
+	t.Run("link texts may show the short link or its destination", func(t *testing.T) {
+		p.configuration.MismatchedLinkTextAction = actionReject
+		defer func() { p.configuration.MismatchedLinkTextAction = "" }()
+
+		assert.Empty(t, p.evaluatePost(&model.Post{UserId: "user1", Message: "[bit.ly/good](https://bit.ly/good)"}, time.Now()))
+		assert.Empty(t, p.evaluatePost(&model.Post{UserId: "user1", Message: "[example.com](https://bit.ly/good)"}, time.Now()))
+		assert.Equal(t, []string{"The link `[bank.com](https://bit.ly/good)` shows `bank.com` but actually points to `example.com`."},
+			p.evaluatePost(&model.Post{UserId: "user1", Message: "[bank.com](https://bit.ly/good)"}, time.Now()))
+	})
+}
+
+func TestHTTPShortLinkResolver(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodHead, r.Method)
+		if r.URL.Path == "/abc" {
+			http.Redirect(w, r, "https://example.com/page", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	resolver := newHTTPShortLinkResolver(time.Second)
+
+	destination, err := resolver.resolve(context.Background(), canonicalize("http", server.URL+"/abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/page", destination)
+
+	destination, err = resolver.resolve(context.Background(), canonicalize("http", server.URL+"/abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/page", destination)
+	assert.Equal(t, 1, requests, "the destination should be cached")
+
+	destination, err = resolver.resolve(context.Background(), canonicalize("http", server.URL+"/unknown"))
+	require.NoError(t, err)
+	assert.Empty(t, destination)
+
+	server.Close()
+	_, err = resolver.resolve(context.Background(), canonicalize("http", server.URL+"/other"))
+	assert.Error(t, err)
+}
+
+func TestResolveShortLinksOverHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+		http.Redirect(w, r, "https://example.com"+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.ShortenerHosts = "127.0.0.1"
+	p.configuration.ShortLinkHTTPResolver = true
+	p.configuration.ShortLinkResolverTimeout = 500
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+	extract := func(paths ...string) []*detectedURL {
+		message := ""
+		for _, path := range paths {
+			message += fmt.Sprintf("[link](%s/%s) ", server.URL, path)
+		}
+		detectedURLs := p.extractURLs(&model.Post{Message: message})
+		p.canonicalizeURLs(detectedURLs)
+		return detectedURLs
+	}
+
+	t.Run("resolves the short links in parallel", func(t *testing.T) {
+		detectedURLs := extract("a", "b", "c", "d", "e")
+		start := time.Now()
+		p.resolveShortLinks(detectedURLs)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		for _, u := range detectedURLs {
+			assert.False(t, u.unresolvedShortLink)
+			assert.Equal(t, "example.com", u.canonical.host)
+		}
+	})
+
+	t.Run("resolves the short links of a post within the timeout", func(t *testing.T) {
+		detectedURLs := extract("f", "slow")
+		start := time.Now()
+		p.resolveShortLinks(detectedURLs)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.False(t, detectedURLs[0].unresolvedShortLink)
+		assert.True(t, detectedURLs[1].unresolvedShortLink)
+	})
+
+	t.Run("limits the short links resolved per post", func(t *testing.T) {
+		var paths []string
+		for i := 0; i <= maxShortLinksPerPost; i++ {
+			paths = append(paths, fmt.Sprintf("limit%d", i))
+		}
+		detectedURLs := extract(paths...)
+		p.resolveShortLinks(detectedURLs)
+		for i, u := range detectedURLs {
+			assert.Equal(t, i == maxShortLinksPerPost, u.unresolvedShortLink, u.rawURL)
+		}
+	})
+}
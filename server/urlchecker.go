@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Fail modes of the URL checkers, when a checker can't check a URL.
+const (
+	failModeOpen   = "open"
+	failModeClosed = "closed"
+
+	// defaultCheckerTimeout is the time the checkers are given to check the URLs of a post when
+	// none is configured.
+	defaultCheckerTimeout = time.Second
+	// maxParallelURLChecks limits how many URLs of a post are checked at the same time.
+	maxParallelURLChecks = 8
+)
+
+// Verdict is the result of a URL checker for a detected URL.
+type Verdict struct {
+	// Action is what should be done with the URL. It's actionOff if the URL passed the check.
+	Action string
+	// Reason explains the action to the user.
+	Reason string
+	// Rewrite is the URL the link is rewritten to, if the action is actionRewrite.
+	Rewrite string
+	// Err is set if the URL couldn't be checked, e.g. because a service is unavailable. The
+	// action is then decided by the fail mode, unless the checker set a fallback action.
+	Err error
+}
+
+// URLChecker checks the detected URLs. New checks are added by implementing this interface and
+// adding the checker to urlCheckers, without changing the hooks.
+type URLChecker interface {
+	// Name returns the name of the check, used in the logs and the schedules.
+	Name() string
+	// Check checks the URL. The post being checked is available with postFromContext.
+	Check(ctx context.Context, u *detectedURL) Verdict
+}
+
+type postContextKey struct{}
+
+// contextWithPost returns a context carrying the post whose URLs are checked.
+func contextWithPost(ctx context.Context, post *model.Post) context.Context {
+	return context.WithValue(ctx, postContextKey{}, post)
+}
+
+// postFromContext returns the post whose URLs are checked, or nil.
+func postFromContext(ctx context.Context) *model.Post {
+	post, _ := ctx.Value(postContextKey{}).(*model.Post)
+	return post
+}
+
+// checkerFunc adapts a urlCheck to the URLChecker interface.
+type checkerFunc struct {
+	name  string
+	check urlCheck
+}
+
+func (c *checkerFunc) Name() string {
+	return c.name
+}
+
+func (c *checkerFunc) Check(ctx context.Context, u *detectedURL) Verdict {
+	v := c.check(u, postFromContext(ctx))
+	if v == nil {
+		return Verdict{Action: actionOff}
+	}
+	return Verdict{Action: v.action, Reason: v.reason}
+}
+
+// cachedVerdict is a verdict kept by a cachingChecker.
+type cachedVerdict struct {
+	verdict   Verdict
+	expiresAt time.Time
+}
+
+// cachingChecker caches the verdicts of a checker by canonical URL, for the checkers calling
+// remote services. Failures aren't cached.
+type cachingChecker struct {
+	URLChecker
+	ttl time.Duration
+
+	// lock synchronizes access to verdicts.
+	lock     sync.Mutex
+	verdicts map[string]cachedVerdict
+}
+
+func newCachingChecker(checker URLChecker, ttl time.Duration) URLChecker {
+	if ttl <= 0 {
+		return checker
+	}
+	return &cachingChecker{
+		URLChecker: checker,
+		ttl:        ttl,
+		verdicts:   map[string]cachedVerdict{},
+	}
+}
+
+func (c *cachingChecker) Check(ctx context.Context, u *detectedURL) Verdict {
+	key := u.canonical.String()
+	now := time.Now()
+
+	c.lock.Lock()
+	cached, ok := c.verdicts[key]
+	c.lock.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.verdict
+	}
+
+	verdict := c.URLChecker.Check(ctx, u)
+	if verdict.Err != nil {
+		return verdict
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.verdicts[key] = cachedVerdict{verdict: verdict, expiresAt: now.Add(c.ttl)}
+	for k, v := range c.verdicts {
+		if now.After(v.expiresAt) {
+			delete(c.verdicts, k)
+		}
+	}
+
+	return verdict
+}
+
+// runChecker runs the checker on the URL until the context is done. If the URL can't be checked,
+// the fallback action of the checker is used. Without one, the link is allowed in the open fail
+// mode, and rejected in the closed fail mode.
+func (p *Plugin) runChecker(ctx context.Context, checker URLChecker, u *detectedURL) Verdict {
+	configuration := p.getConfiguration()
+	verdict := checker.Check(ctx, u)
+	if verdict.Err == nil {
+		return verdict
+	}
+
+	p.API.LogWarn("Failed to check a link", "check", checker.Name(), "host", u.canonical.host, "error", verdict.Err.Error())
+	if verdict.Action != "" {
+		return verdict
+	}
+	if configuration.CheckerFailMode != failModeClosed {
+		return Verdict{Action: actionOff}
+	}
+	return Verdict{
+		Action: actionReject,
+		Reason: fmt.Sprintf("The link to `%s` couldn't be checked.", u.canonical.String()),
+	}
+}
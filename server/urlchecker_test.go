@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// stubChecker returns the verdict of its function, and counts its calls.
+type stubChecker struct {
+	check func(ctx context.Context, u *detectedURL) Verdict
+	calls int
+}
+
+func (c *stubChecker) Name() string {
+	return "stub"
+}
+
+func (c *stubChecker) Check(ctx context.Context, u *detectedURL) Verdict {
+	c.calls++
+	return c.check(ctx, u)
+}
+
+func TestRunChecker(t *testing.T) {
+	u := &detectedURL{canonical: canonicalize("https", "https://example.com")}
+	failing := &stubChecker{check: func(context.Context, *detectedURL) Verdict {
+		return Verdict{Err: errors.New("unavailable")}
+	}}
+
+	t.Run("fail open", func(t *testing.T) {
+		p := newTestPlugin(t, false, "https", "", "")
+		p.API = &mockAPI{}
+		assert.Equal(t, Verdict{Action: actionOff}, p.runChecker(context.Background(), failing, u))
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		p := newTestPlugin(t, false, "https", "", "")
+		p.configuration.CheckerFailMode = failModeClosed
+		p.API = &mockAPI{}
+		assert.Equal(t, Verdict{Action: actionReject, Reason: "The link to `https://example.com` couldn't be checked."}, p.runChecker(context.Background(), failing, u))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p := newTestPlugin(t, false, "https", "", "")
+		p.configuration.CheckerFailMode = failModeClosed
+		p.API = &mockAPI{}
+		slow := &stubChecker{check: func(ctx context.Context, _ *detectedURL) Verdict {
+			select {
+			case <-ctx.Done():
+				return Verdict{Err: ctx.Err()}
+			case <-time.After(time.Second):
+				return Verdict{Action: actionOff}
+			}
+		}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		assert.Equal(t, actionReject, p.runChecker(ctx, slow, u).Action)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("post in the context", func(t *testing.T) {
+		p := newTestPlugin(t, false, "https", "", "")
+		post := &model.Post{Id: "post1"}
+		checker := &stubChecker{check: func(ctx context.Context, _ *detectedURL) Verdict {
+			assert.Equal(t, post, postFromContext(ctx))
+			return Verdict{Action: actionOff}
+		}}
+		p.runChecker(contextWithPost(context.Background(), post), checker, u)
+		assert.Equal(t, 1, checker.calls)
+	})
+}
+
+func TestCachingChecker(t *testing.T) {
+	failure := true
+	checker := &stubChecker{check: func(context.Context, *detectedURL) Verdict {
+		if failure {
+			return Verdict{Err: errors.New("unavailable")}
+		}
+		return Verdict{Action: actionReject, Reason: "denied"}
+	}}
+	cached := newCachingChecker(checker, time.Minute)
+	u := &detectedURL{canonical: canonicalize("https", "https://example.com")}
+
+	assert.Error(t, cached.Check(context.Background(), u).Err)
+	failure = false
+	assert.Equal(t, Verdict{Action: actionReject, Reason: "denied"}, cached.Check(context.Background(), u))
+	assert.Equal(t, Verdict{Action: actionReject, Reason: "denied"}, cached.Check(context.Background(), u))
+	assert.Equal(t, 2, checker.calls, "failures shouldn't be cached, verdicts should")
+
+	other := &detectedURL{canonical: canonicalize("https", "https://example.org")}
+	cached.Check(context.Background(), other)
+	assert.Equal(t, 3, checker.calls)
+
+	assert.Same(t, checker, newCachingChecker(checker, 0), "a zero TTL disables the cache")
+}
+
+func TestRunChecksTimeout(t *testing.T) {
+	release := make(chan struct{})
+	service := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer service.Close()
+	defer close(release)
+
+	p := newTestPlugin(t, false, "https", "", "")
+	p.configuration.ReputationAction = actionReject
+	p.configuration.ReputationServiceURL = service.URL
+	p.configuration.ReputationServiceTimeout = 10000
+	p.configuration.CheckerTimeout = 100
+	p.configuration.CheckerFailMode = failModeClosed
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	var links []string
+	for i := 0; i < 20; i++ {
+		links = append(links, fmt.Sprintf("https://host%d.example.com", i))
+	}
+	post := &model.Post{Message: strings.Join(links, " ")}
+	detectedURLs := p.extractURLs(post)
+	p.canonicalizeURLs(detectedURLs)
+
+	start := time.Now()
+	violations := p.runChecks(detectedURLs, post, time.Now())
+	assert.Less(t, time.Since(start), time.Second, "the links of a post should be checked within one timeout")
+	require.Len(t, violations, 20)
+	for i, v := range violations {
+		assert.Same(t, detectedURLs[i], v.url, "the violations should be in the order of the links")
+		assert.Equal(t, actionReject, v.action)
+	}
+}
+
+func TestRunChecksWithCheckers(t *testing.T) {
+	p := newTestPlugin(t, false, "https", "", "")
+	p.configuration.HomoglyphAction = actionWarn
+	p.configuration.ProtectedDomains = "paypal.com"
+	p.configuration.ReputationAction = actionReject
+	p.configuration.DeniedHosts = "evil.com"
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	post := &model.Post{Message: "[a](https://pаypal.com) [b](https://www.evil.com/x)"}
+	detectedURLs := p.extractURLs(post)
+	p.canonicalizeURLs(detectedURLs)
+
+	violations := p.runChecks(detectedURLs, post, time.Now())
+	require.Len(t, violations, 2)
+	assert.Equal(t, checkHomoglyph, violations[0].check)
+	assert.Equal(t, actionWarn, violations[0].action)
+	assert.Equal(t, checkerDeniedHosts, violations[1].check)
+	assert.Equal(t, actionReject, violations[1].action)
+}
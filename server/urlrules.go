@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/Brightscout/mattermost-plugin-link-filter/server/util"
+)
+
+const (
+	checkURLRules = "url_rules"
+
+	urlRuleAllow = "allow"
+	urlRuleDeny  = "deny"
+
+	// urlRuleRegexPrefix starts a path given as a regular expression.
+	urlRuleRegexPrefix = "regex:"
+)
+
+// urlRule allows or denies the URLs of a host, optionally restricted to some paths and query
+// parameters.
+type urlRule struct {
+	text  string
+	allow bool
+	// host is the canonical host, or a domain whose subdomains all match if subdomains is set.
+	host       string
+	subdomains bool
+	// path matches the canonical path. It's nil if the rule applies to all the paths.
+	path *regexp.Regexp
+	// query are the conditions on the query parameters, which must all match.
+	query []queryCondition
+}
+
+// queryCondition matches URLs with a query parameter, optionally with a value matching a glob pattern.
+type queryCondition struct {
+	key   string
+	value *regexp.Regexp
+}
+
+// parseURLRules parses one rule per line, in the form `<allow|deny> <host>[/path] [?key[=value]...]`.
+// The path is a prefix, a glob pattern where `*` matches any characters, or a regular expression
+// given as a separate `regex:<expression>` field. The host can start with `*.` to match its
+// subdomains.
+func parseURLRules(text string) ([]*urlRule, error) {
+	var rules []*urlRule
+	for _, line := range util.TrimString(strings.Split(text, "\n")) {
+		rule, err := parseURLRule(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid URL rule %q", line)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseURLRule(line string) (*urlRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.New("expected <allow|deny> <host>[/path] [?key[=value]...]")
+	}
+
+	rule := &urlRule{text: line}
+	switch strings.ToLower(fields[0]) {
+	case urlRuleAllow:
+		rule.allow = true
+	case urlRuleDeny:
+	default:
+		return nil, errors.Errorf("unknown action %q, expected allow or deny", fields[0])
+	}
+
+	host, path, hasPath := strings.Cut(fields[1], "/")
+	if strings.HasPrefix(host, "*.") {
+		rule.subdomains = true
+		host = strings.TrimPrefix(host, "*.")
+	}
+	rule.host, _, _ = canonicalizeHost(host)
+	if rule.host == "" {
+		return nil, errors.New("missing host")
+	}
+	if hasPath {
+		rule.path = pathPattern("/" + path)
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, urlRuleRegexPrefix):
+			if hasPath {
+				return nil, errors.New("the path can't be given both after the host and as a regular expression")
+			}
+			pattern, err := regexp.Compile(strings.TrimPrefix(field, urlRuleRegexPrefix))
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid path regular expression")
+			}
+			rule.path = pattern
+		case strings.HasPrefix(field, "?"):
+			key, value, hasValue := strings.Cut(strings.TrimPrefix(field, "?"), "=")
+			if key == "" {
+				return nil, errors.Errorf("invalid query condition %q", field)
+			}
+			condition := queryCondition{key: key}
+			if hasValue {
+				condition.value = globPattern(value)
+			}
+			rule.query = append(rule.query, condition)
+		default:
+			return nil, errors.Errorf("unexpected field %q", field)
+		}
+	}
+
+	return rule, nil
+}
+
+// pathPattern returns the regular expression matching a path pattern. Patterns with `*` are glob
+// patterns, others are prefixes matching whole path segments, e.g. /org matches /org and
+// /org/repo but not /organization.
+func pathPattern(pattern string) *regexp.Regexp {
+	if strings.Contains(pattern, "*") {
+		return globPattern(pattern)
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(pattern))
+	}
+
+	return regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "(?:/|$)")
+}
+
+// globPattern returns the regular expression matching a glob pattern, where `*` matches any characters.
+func globPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+}
+
+// matchesHost returns whether the rule applies to the host.
+func (r *urlRule) matchesHost(host string) bool {
+	if r.subdomains {
+		return strings.HasSuffix(host, "."+r.host)
+	}
+	return host == r.host
+}
+
+// matches returns whether the rule applies to the canonical URL.
+func (r *urlRule) matches(u *canonicalURL) bool {
+	if !r.matchesHost(u.host) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(u.path) {
+		return false
+	}
+	if len(r.query) == 0 {
+		return true
+	}
+
+	query, err := url.ParseQuery(u.rawQuery)
+	if err != nil {
+		return false
+	}
+	for _, condition := range r.query {
+		values, ok := query[condition.key]
+		if !ok {
+			return false
+		}
+		if condition.value != nil && !anyMatch(condition.value, values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func anyMatch(pattern *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateURLRules returns whether the URL is denied, and the rule which decided, if any. The
+// first rule matching the URL decides. URLs which match no rule are denied if their host has
+// allow rules, so allowing some paths of a host denies the others.
+func (p *Plugin) evaluateURLRules(u *canonicalURL) (denied bool, rule *urlRule) {
+	hostHasAllowRules := false
+	for _, r := range p.getConfiguration().urlRules {
+		if r.matches(u) {
+			return !r.allow, r
+		}
+		if r.allow && r.matchesHost(u.host) {
+			hostHasAllowRules = true
+		}
+	}
+
+	return hostHasAllowRules, nil
+}
+
+// checkURLRules flags the URLs denied by the URL rules.
+func (p *Plugin) checkURLRules(u *detectedURL, _ *model.Post) *violation {
+	if u.canonical.host == "" || p.isSafeLink(u) {
+		return nil
+	}
+
+	denied, rule := p.evaluateURLRules(u.canonical)
+	if !denied {
+		return nil
+	}
+
+	reason := fmt.Sprintf("The link to `%s` isn't one of the allowed links for `%s`.", u.canonical.String(), u.canonical.host)
+	if rule != nil {
+		reason = fmt.Sprintf("The link to `%s` is denied by the rule `%s`.", u.canonical.String(), rule.text)
+	}
+
+	return &violation{
+		url:    u,
+		check:  checkURLRules,
+		action: p.getConfiguration().URLRuleAction,
+		reason: reason,
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+func TestParseURLRules(t *testing.T) {
+	for _, text := range []string{
+		"allow",
+		"permit github.com",
+		"allow /path",
+		"allow github.com regex:(",
+		"allow github.com/org regex:^/org",
+		"allow github.com ?",
+		"allow github.com org",
+	} {
+		_, err := parseURLRules(text)
+		assert.Error(t, err, text)
+	}
+
+	rules, err := parseURLRules("allow GitHub.com/our-org/*\n\ndeny *.google.com regex:^/drive ?id=abc*")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "github.com", rules[0].host)
+	assert.True(t, rules[1].subdomains)
+	assert.Len(t, rules[1].query, 1)
+}
+
+func TestCheckURLRules(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.URLRuleAction = actionReject
+	p.configuration.URLRules = `allow github.com/our-org/*
+allow github.com/settings
+allow drive.google.com/drive/folders/0AOurDrive*
+allow drive.google.com/open ?id=0AOurDrive*
+deny *.example.com regex:^/(login|signin)$
+deny example.org ?redirect`
+	require.NoError(t, p.initConfiguration(p.configuration))
+	p.API = &mockAPI{}
+
+	var tests = []struct {
+		url     string
+		allowed bool
+	}{
+		{url: "https://github.com/our-org/repo", allowed: true},
+		{url: "https://GITHUB.com/our-org/repo/issues/1", allowed: true},
+		{url: "https://github.com/other-org/repo", allowed: false},
+		{url: "https://github.com/our-org", allowed: false},
+		{url: "https://github.com/our-org/../evil-org/x", allowed: false},
+		{url: "https://github.com/our-org%2F..%2Fevil-org/x", allowed: false},
+		{url: "https://github.com/evil-org/../our-org/x", allowed: true},
+		{url: "https://github.com/settings/profile", allowed: true},
+		{url: "https://github.com/settingsx", allowed: false},
+		{url: "https://drive.google.com/drive/folders/0AOurDriveXYZ", allowed: true},
+		{url: "https://drive.google.com/drive/folders/0AOtherDrive", allowed: false},
+		{url: "https://drive.google.com/open?id=0AOurDriveXYZ", allowed: true},
+		{url: "https://drive.google.com/open?id=0AOtherDrive", allowed: false},
+		{url: "https://drive.google.com/open", allowed: false},
+		{url: "https://docs.google.com/document/d/1", allowed: true},
+		{url: "https://www.example.com/login", allowed: false},
+		{url: "https://www.example.com/login/help", allowed: true},
+		{url: "https:www.example.com/login", allowed: false},
+		{url: "https:/www.example.com/login", allowed: false},
+		{url: `https:\\www.example.com\login`, allowed: false},
+		{url: "https://example.com/login", allowed: true},
+		{url: "https://example.org/page?redirect=https://evil.com", allowed: false},
+		{url: "https://example.org/page", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "[link](" + test.url + ")"}, time.Now())
+			if test.allowed {
+				assert.Empty(t, reasons)
+			} else {
+				assert.Len(t, reasons, 1)
+			}
+		})
+	}
+
+	t.Run("names the rule denying the link", func(t *testing.T) {
+		reasons := p.evaluatePost(&model.Post{UserId: "user1", Message: "https://www.example.com/signin"}, time.Now())
+		assert.Equal(t, []string{"The link to `https://www.example.com/signin` is denied by the rule `deny *.example.com regex:^/(login|signin)$`."}, reasons)
+
+		reasons = p.evaluatePost(&model.Post{UserId: "user1", Message: "See <https://www.example.com/signin>"}, time.Now())
+		assert.Equal(t, []string{"The link to `https://www.example.com/signin` is denied by the rule `deny *.example.com regex:^/(login|signin)$`."}, reasons)
+
+		reasons = p.evaluatePost(&model.Post{UserId: "user1", Message: "https://github.com/other-org"}, time.Now())
+		assert.Equal(t, []string{"The link to `https://github.com/other-org` isn't one of the allowed links for `github.com`."}, reasons)
+	})
+}
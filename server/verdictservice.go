@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxVerdictSize limits the size of a response of the reputation service.
+	maxVerdictSize = 64 * 1024
+	// defaultServiceTimeout is the time the reputation service is given to answer when none is configured.
+	defaultServiceTimeout = 500 * time.Millisecond
+
+	// Headers of the requests sent to the reputation service, so it can check they come from the plugin.
+	signatureHeader = "X-Link-Filter-Signature"
+	timestampHeader = "X-Link-Filter-Timestamp"
+
+	verdictAllow   = "allow"
+	verdictDeny    = "deny"
+	verdictRewrite = "rewrite"
+
+	// Actions used when the reputation service is unavailable. With the fail mode fallback, the
+	// checker fail mode decides.
+	serviceFallbackFailMode = "fail_mode"
+	serviceFallbackAllow    = "allow"
+	serviceFallbackDeny     = "deny"
+)
+
+// serviceVerdictRequest is sent to the reputation service for each detected URL. The message of
+// the post is never sent.
+type serviceVerdictRequest struct {
+	URL    string `json:"url"`
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+}
+
+// serviceVerdictResponse is the answer of the reputation service. URL is the URL the link is
+// rewritten to with the rewrite verdict.
+type serviceVerdictResponse struct {
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason"`
+	URL     string `json:"url"`
+}
+
+// signRequest returns the signature of a request to the reputation service: the hex encoded
+// HMAC-SHA256 of the timestamp and the body, separated by a dot.
+func signRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// serviceChecker asks an external HTTP service for the verdict of each URL. Requests are signed
+// when a secret is configured.
+type serviceChecker struct {
+	action     string
+	serviceURL string
+	secret     string
+	// timeout is the time the service is given to answer. The checker timeout still applies if it's shorter.
+	timeout time.Duration
+	// fallback is the action used when the service is unavailable.
+	fallback string
+	client   *http.Client
+}
+
+func (c *serviceChecker) Name() string {
+	return checkerReputationService
+}
+
+func (c *serviceChecker) Check(ctx context.Context, u *detectedURL) Verdict {
+	verdict, err := c.requestVerdict(ctx, u)
+	if err == nil {
+		return verdict
+	}
+
+	switch c.fallback {
+	case serviceFallbackAllow:
+		return Verdict{Action: actionOff, Err: err}
+	case serviceFallbackDeny:
+		return Verdict{
+			Action: c.action,
+			Reason: fmt.Sprintf("The link to `%s` couldn't be verified by the reputation service.", u.canonical.String()),
+			Err:    err,
+		}
+	default:
+		return Verdict{Err: err}
+	}
+}
+
+func (c *serviceChecker) requestVerdict(ctx context.Context, u *detectedURL) (Verdict, error) {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = defaultServiceTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(&serviceVerdictRequest{
+		URL:    u.canonical.String(),
+		Scheme: u.canonical.scheme,
+		Host:   u.canonical.host,
+	})
+	if err != nil {
+		return Verdict{}, errors.Wrap(err, "failed to marshal the verdict request")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.serviceURL, bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, errors.Wrap(err, "failed to create the verdict request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(timestampHeader, timestamp)
+		request.Header.Set(signatureHeader, signRequest(c.secret, timestamp, data))
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return Verdict{}, errors.Wrap(err, "failed to request the verdict")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Verdict{}, errors.Errorf("the reputation service answered %s", response.Status)
+	}
+
+	var answer serviceVerdictResponse
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxVerdictSize)).Decode(&answer); err != nil {
+		return Verdict{}, errors.Wrap(err, "failed to decode the verdict")
+	}
+
+	switch answer.Verdict {
+	case verdictAllow:
+		return Verdict{Action: actionOff}, nil
+	case verdictDeny:
+		reason := fmt.Sprintf("The link to `%s` is denied by the reputation service.", u.canonical.String())
+		if answer.Reason != "" {
+			reason = fmt.Sprintf("The link to `%s` is denied by the reputation service: %s", u.canonical.String(), answer.Reason)
+		}
+		return Verdict{Action: c.action, Reason: reason}, nil
+	case verdictRewrite:
+		target := parseDestination(answer.URL)
+		if target == nil || strings.ContainsAny(answer.URL, " \t\n()[]<>") {
+			return Verdict{}, errors.Errorf("invalid rewrite URL %q, expected an http or https URL", answer.URL)
+		}
+		reason := fmt.Sprintf("The link to `%s` has been replaced with `%s`.", u.canonical.String(), target.String())
+		if answer.Reason != "" {
+			reason = fmt.Sprintf("The link to `%s` has been replaced with `%s`: %s", u.canonical.String(), target.String(), answer.Reason)
+		}
+		return Verdict{Action: actionRewrite, Reason: reason, Rewrite: answer.URL}, nil
+	default:
+		return Verdict{}, errors.Errorf("unknown verdict %q", answer.Verdict)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// newMockVerdictService starts a local reputation service answering the verdicts of the map by
+// URL, and allowing the other URLs. If secret is set, requests without a valid signature are
+// rejected.
+func newMockVerdictService(t *testing.T, secret string, verdicts map[string]serviceVerdictResponse) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if secret != "" && r.Header.Get(signatureHeader) != signRequest(secret, r.Header.Get(timestampHeader), body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var request serviceVerdictRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		answer, ok := verdicts[request.URL]
+		if !ok {
+			answer = serviceVerdictResponse{Verdict: verdictAllow}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&answer)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestSignRequest(t *testing.T) {
+	signature := signRequest("secret", "1700000000", []byte(`{"url":"https://example.com"}`))
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.Len(t, signature, 7+64)
+	assert.Equal(t, signature, signRequest("secret", "1700000000", []byte(`{"url":"https://example.com"}`)))
+	assert.NotEqual(t, signature, signRequest("secret", "1700000001", []byte(`{"url":"https://example.com"}`)))
+	assert.NotEqual(t, signature, signRequest("other", "1700000000", []byte(`{"url":"https://example.com"}`)))
+}
+
+func TestServiceChecker(t *testing.T) {
+	u := &detectedURL{canonical: canonicalize("https", "https://example.com")}
+	service := newMockVerdictService(t, "secret", map[string]serviceVerdictResponse{
+		"https://example.com": {Verdict: verdictDeny},
+	})
+
+	checker := &serviceChecker{action: actionReject, serviceURL: service.URL, secret: "secret", client: &http.Client{}}
+	assert.Equal(t, Verdict{Action: actionReject, Reason: "The link to `https://example.com` is denied by the reputation service."}, checker.Check(context.Background(), u))
+
+	checker.secret = "wrong"
+	assert.EqualError(t, checker.Check(context.Background(), u).Err, "the reputation service answered 401 Unauthorized")
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"verdict": "maybe"}`))
+	}))
+	defer broken.Close()
+	checker.serviceURL = broken.URL
+	assert.EqualError(t, checker.Check(context.Background(), u).Err, `unknown verdict "maybe"`)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer slow.Close()
+	checker.serviceURL = slow.URL
+	checker.timeout = 20 * time.Millisecond
+	start := time.Now()
+	assert.Error(t, checker.Check(context.Background(), u).Err)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	checker.fallback = serviceFallbackAllow
+	verdict := checker.Check(context.Background(), u)
+	assert.Error(t, verdict.Err)
+	assert.Equal(t, actionOff, verdict.Action)
+
+	checker.fallback = serviceFallbackDeny
+	verdict = checker.Check(context.Background(), u)
+	assert.Error(t, verdict.Err)
+	assert.Equal(t, actionReject, verdict.Action)
+	assert.Equal(t, "The link to `https://example.com` couldn't be verified by the reputation service.", verdict.Reason)
+}
+
+func TestServiceCheckerVerdicts(t *testing.T) {
+	service := newMockVerdictService(t, "", map[string]serviceVerdictResponse{
+		"https://phishing.example/login":    {Verdict: verdictDeny, Reason: "Phishing kit"},
+		"https://tracker.example/article":   {Verdict: verdictRewrite, URL: "https://example.com/article"},
+		"https://invalid.example/rewrite":   {Verdict: verdictRewrite, URL: "javascript:alert(1)"},
+		"https://markdown.example/rewrite/": {Verdict: verdictRewrite, URL: "https://example.com/a) [x](https://evil.com"},
+	})
+
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.ReputationAction = actionReject
+	p.configuration.ReputationServiceURL = service.URL
+	p.configuration.ReputationServiceFallback = serviceFallbackDeny
+	require.NoError(t, p.initConfiguration(p.configuration))
+	api := &mockAPI{}
+	p.API = api
+
+	var tests = []struct {
+		name            string
+		message         string
+		expectedError   string
+		expectedMessage string
+		expectedWarning string
+	}{
+		{
+			name:            "allowed",
+			message:         "[site](https://example.com)",
+			expectedMessage: "[site](https://example.com)",
+		},
+		{
+			name:          "denied",
+			message:       "[login](https://phishing.example/login)",
+			expectedError: "Links not allowed: The link to `https://phishing.example/login` is denied by the reputation service: Phishing kit",
+		},
+		{
+			name:            "rewritten",
+			message:         "read https://tracker.example/article",
+			expectedMessage: "read https://example.com/article",
+			expectedWarning: "The link to `https://tracker.example/article` has been replaced with `https://example.com/article`.",
+		},
+		{
+			name:          "invalid rewrite falls back",
+			message:       "[x](https://invalid.example/rewrite)",
+			expectedError: "Links not allowed: The link to `https://invalid.example/rewrite` couldn't be verified by the reputation service.",
+		},
+		{
+			name:          "rewrite breaking the markdown falls back",
+			message:       "[x](https://markdown.example/rewrite/)",
+			expectedError: "Links not allowed: The link to `https://markdown.example/rewrite/` couldn't be verified by the reputation service.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api.sentEphemeralPost = nil
+			post := &model.Post{UserId: "user1", ChannelId: "channel1", Message: test.message}
+			detectedURLs := p.extractURLs(post)
+			p.canonicalizeURLs(detectedURLs)
+
+			assert.Equal(t, test.expectedError, p.FilterPost(detectedURLs, post, false))
+			if test.expectedError == "" {
+				assert.Equal(t, test.expectedMessage, post.Message)
+			}
+			if test.expectedWarning != "" {
+				require.NotNil(t, api.sentEphemeralPost)
+				assert.Contains(t, api.sentEphemeralPost.Message, test.expectedWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Rules reported in the violation events, besides the names of the checks.
+const (
+	ruleProtocols        = "protocols"
+	ruleRewriteProtocols = "rewrite_protocols"
+	ruleChannelFields    = "channel_fields"
+)
+
+const (
+	// violationQueueSize is the number of events waiting to be sent to the violation webhook.
+	// Events are dropped when the queue is full, so the hooks are never slowed down.
+	violationQueueSize = 1000
+	// violationWebhookAttempts is how many times an event is sent before it's dropped.
+	violationWebhookAttempts = 3
+	// violationWebhookTimeout is the time the webhook is given to answer each attempt.
+	violationWebhookTimeout = 5 * time.Second
+)
+
+// violationEvent is sent to the violation webhook for each link the filter rejects, rewrites or
+// warns about. Decisions about the whole post, like the link limits, have no scheme or host.
+type violationEvent struct {
+	Timestamp int64 `json:"timestamp"`
+	// PostID is only set for edits, as new posts are filtered before they're saved and given an ID.
+	PostID    string `json:"post_id,omitempty"`
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	TeamID    string `json:"team_id,omitempty"`
+	Scheme    string `json:"scheme,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Rule      string `json:"rule"`
+	Action    string `json:"action"`
+	// Reason is omitted when the privacy mode applies to the post, as it can contain the URL, and
+	// when the channel type can't be confirmed while the privacy mode is enabled.
+	Reason string `json:"reason,omitempty"`
+	IsEdit bool   `json:"is_edit"`
+}
+
+// violationNotifier sends the violation events to the webhook from a background goroutine.
+type violationNotifier struct {
+	queue  chan *violationEvent
+	client *http.Client
+	// backoff is the delay before the second attempt to send an event, doubled for each attempt.
+	backoff time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newViolationNotifier() *violationNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &violationNotifier{
+		queue:   make(chan *violationEvent, violationQueueSize),
+		client:  &http.Client{Timeout: violationWebhookTimeout},
+		backoff: time.Second,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// startViolationNotifier starts sending the violation events to the webhook.
+func (p *Plugin) startViolationNotifier() {
+	notifier := newViolationNotifier()
+	p.violationNotifier.Store(notifier)
+	go p.runViolationNotifier(notifier)
+}
+
+// stopViolationNotifier stops sending the violation events. The events still queued are dropped.
+func (p *Plugin) stopViolationNotifier() {
+	notifier := p.violationNotifier.Swap(nil)
+	if notifier == nil {
+		return
+	}
+	notifier.cancel()
+	<-notifier.done
+}
+
+func (p *Plugin) runViolationNotifier(notifier *violationNotifier) {
+	defer close(notifier.done)
+	for {
+		select {
+		case <-notifier.ctx.Done():
+			return
+		case event := <-notifier.queue:
+			p.sendViolationEvent(notifier, event)
+		}
+	}
+}
+
+// reportViolation records the decision for a link of the post, or for the whole post if u is nil,
+// in the audit log, and queues an event for the violation webhook. It never blocks: the event is
+// dropped if the queue is full.
+func (p *Plugin) reportViolation(post *model.Post, isEdit bool, u *detectedURL, rule, action, reason string) {
+	p.auditDecision(post, isEdit, u, rule, action, reason)
+
+	notifier := p.violationNotifier.Load()
+	if notifier == nil || strings.TrimSpace(p.getConfiguration().ViolationWebhookURL) == "" {
+		return
+	}
+
+	event := &violationEvent{
+		Timestamp: time.Now().UnixMilli(),
+		PostID:    post.Id,
+		UserID:    post.UserId,
+		ChannelID: post.ChannelId,
+		Rule:      rule,
+		Action:    action,
+		Reason:    reason,
+		IsEdit:    isEdit,
+	}
+	if u != nil {
+		if u.canonical == nil {
+			u.canonical = canonicalize(u.protocol, u.rawURL)
+		}
+		event.Scheme = u.canonical.scheme
+		event.Host = u.canonical.host
+	}
+
+	select {
+	case notifier.queue <- event:
+	default:
+		p.API.LogWarn("Dropped a violation event, the webhook queue is full", "rule", rule)
+	}
+}
+
+// reportInvalidProtocols queues an event for each link of the post rejected because of its
+// scheme, including the obfuscated dangerous schemes which weren't detected as links.
+func (p *Plugin) reportInvalidProtocols(detectedURLs []*detectedURL, post *model.Post, isEdit bool, invalidURLProtocols []string) {
+	reported := make(map[string]bool)
+	for _, u := range detectedURLs {
+		protocol := strings.ToLower(u.protocol)
+		if u.rewritten || !slices.Contains(invalidURLProtocols, u.protocol) && !slices.Contains(invalidURLProtocols, protocol) {
+			continue
+		}
+		reported[protocol] = true
+		p.reportViolation(post, isEdit, u, ruleProtocols, actionReject, "Scheme not allowed: "+protocol)
+	}
+
+	for _, protocol := range invalidURLProtocols {
+		if !reported[strings.ToLower(protocol)] {
+			p.reportViolation(post, isEdit, &detectedURL{protocol: protocol, rawURL: protocol + ":"}, ruleProtocols, actionReject, "Scheme not allowed: "+protocol)
+		}
+	}
+}
+
+// reportRewrittenProtocols queues an event for each plain link of the post rewritten by
+// rewriteLinks to prevent autolinking. It's called once the post is allowed.
+func (p *Plugin) reportRewrittenProtocols(detectedURLs []*detectedURL, post *model.Post, isEdit bool) {
+	rewriteProtocolList := p.getConfiguration().rewriteProtocolList
+	for _, u := range detectedURLs {
+		if u.isPlainText && slices.Contains(rewriteProtocolList, u.protocol) {
+			p.reportViolation(post, isEdit, u, ruleRewriteProtocols, actionRewrite, "Scheme rewritten: "+u.protocol)
+		}
+	}
+}
+
+// sendViolationEvent completes the event with the team of the channel, removes the reason if
+// the privacy mode applies, and sends it to the webhook, retrying with an exponential backoff.
+func (p *Plugin) sendViolationEvent(notifier *violationNotifier, event *violationEvent) {
+	configuration := p.getConfiguration()
+	webhookURL := strings.TrimSpace(configuration.ViolationWebhookURL)
+	if webhookURL == "" {
+		return
+	}
+
+	info, err := p.getChannelInfo(event.ChannelID)
+	if err != nil {
+		p.API.LogWarn("Failed to get the channel of a violation event", "channel_id", event.ChannelID, "error", err.Error())
+	} else {
+		event.TeamID = info.teamID
+	}
+	if configuration.DirectMessagePrivacyMode && (info == nil || isDirectMessageChannel(info)) {
+		event.Reason = ""
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		p.API.LogError("Failed to marshal a violation event", "error", err.Error())
+		return
+	}
+
+	backoff := notifier.backoff
+	for attempt := 1; ; attempt++ {
+		err = postViolationEvent(notifier, webhookURL, configuration.ViolationWebhookSecret, data)
+		if err == nil {
+			return
+		}
+		if attempt == violationWebhookAttempts {
+			break
+		}
+
+		select {
+		case <-notifier.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	p.API.LogWarn("Failed to send a violation event to the webhook", "rule", event.Rule, "error", err.Error())
+}
+
+func postViolationEvent(notifier *violationNotifier, webhookURL, secret string, data []byte) error {
+	request, err := http.NewRequestWithContext(notifier.ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the webhook request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(timestampHeader, timestamp)
+		request.Header.Set(signatureHeader, signRequest(secret, timestamp, data))
+	}
+
+	response, err := notifier.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to send the webhook request")
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.Errorf("the webhook answered %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// newMockViolationWebhook starts a local violation webhook sending the events it receives to the
+// returned channel. The first requests, as many as failures, are answered with an error. If
+// secret is set, requests without a valid signature are rejected.
+func newMockViolationWebhook(t *testing.T, secret string, failures int32) (*httptest.Server, chan *violationEvent) {
+	events := make(chan *violationEvent, 10)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if secret != "" && r.Header.Get(signatureHeader) != signRequest(secret, r.Header.Get(timestampHeader), body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var event violationEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events <- &event
+	}))
+	t.Cleanup(server.Close)
+
+	return server, events
+}
+
+func newViolationWebhookTestPlugin(t *testing.T, webhookURL string) *Plugin {
+	p := newTestPlugin(t, false, "http,https", "", "tel")
+	p.configuration.ViolationWebhookURL = webhookURL
+	p.configuration.ViolationWebhookSecret = "secret"
+	p.API = &mockAPI{channels: map[string]*model.Channel{
+		"channel1": {Id: "channel1", TeamId: "team1", Type: model.CHANNEL_OPEN},
+		"dm1":      {Id: "dm1", Type: model.CHANNEL_DIRECT},
+	}}
+
+	p.startViolationNotifier()
+	p.violationNotifier.Load().backoff = time.Millisecond
+	t.Cleanup(p.stopViolationNotifier)
+
+	return p
+}
+
+func receiveViolationEvent(t *testing.T, events chan *violationEvent) *violationEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no violation event received")
+		return nil
+	}
+}
+
+func TestViolationWebhook(t *testing.T) {
+	server, events := newMockViolationWebhook(t, "secret", 0)
+	p := newViolationWebhookTestPlugin(t, server.URL)
+
+	var tests = []struct {
+		name          string
+		post          *model.Post
+		expectedEvent violationEvent
+	}{
+		{
+			name: "rejected scheme",
+			post: &model.Post{UserId: "user1", ChannelId: "channel1", Message: "[file](ftp://files.example.com/report.pdf)"},
+			expectedEvent: violationEvent{
+				UserID: "user1", ChannelID: "channel1", TeamID: "team1",
+				Scheme: "ftp", Host: "files.example.com", Rule: ruleProtocols, Action: actionReject,
+				Reason: "Scheme not allowed: ftp",
+			},
+		},
+		{
+			name: "rewritten scheme",
+			post: &model.Post{UserId: "user1", ChannelId: "channel1", Message: "call tel:123456"},
+			expectedEvent: violationEvent{
+				UserID: "user1", ChannelID: "channel1", TeamID: "team1",
+				Scheme: "tel", Rule: ruleRewriteProtocols, Action: actionRewrite,
+				Reason: "Scheme rewritten: tel",
+			},
+		},
+		{
+			name: "reason removed in private channels",
+			post: &model.Post{UserId: "user1", ChannelId: "dm1", Message: "[file](ftp://files.example.com/report.pdf)"},
+			expectedEvent: violationEvent{
+				UserID: "user1", ChannelID: "dm1",
+				Scheme: "ftp", Host: "files.example.com", Rule: ruleProtocols, Action: actionReject,
+			},
+		},
+		{
+			name: "reason removed when the channel is unknown",
+			post: &model.Post{UserId: "user1", ChannelId: "unknown", Message: "[file](ftp://files.example.com/report.pdf)"},
+			expectedEvent: violationEvent{
+				UserID: "user1", ChannelID: "unknown",
+				Scheme: "ftp", Host: "files.example.com", Rule: ruleProtocols, Action: actionReject,
+			},
+		},
+	}
+
+	p.configuration.DirectMessagePrivacyMode = true
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _ = p.MessageWillBePosted(nil, test.post)
+
+			event := receiveViolationEvent(t, events)
+			assert.NotZero(t, event.Timestamp)
+			event.Timestamp = 0
+			assert.Equal(t, test.expectedEvent, *event)
+		})
+	}
+
+	t.Run("post ID of edits", func(t *testing.T) {
+		_, _ = p.MessageWillBeUpdated(nil, &model.Post{Id: "post1", UserId: "user1", ChannelId: "channel1", Message: "[file](ftp://files.example.com/report.pdf)"}, nil)
+
+		event := receiveViolationEvent(t, events)
+		assert.Equal(t, "post1", event.PostID)
+		assert.True(t, event.IsEdit)
+	})
+}
+
+func TestViolationWebhookRetries(t *testing.T) {
+	server, events := newMockViolationWebhook(t, "", violationWebhookAttempts-1)
+	p := newViolationWebhookTestPlugin(t, server.URL)
+
+	p.reportViolation(&model.Post{UserId: "user1", ChannelId: "channel1"}, true, nil, ruleLinkLimits, actionReject, "Too many links")
+	event := receiveViolationEvent(t, events)
+	assert.Equal(t, ruleLinkLimits, event.Rule)
+	assert.True(t, event.IsEdit)
+	assert.Empty(t, event.Host)
+}
+
+func TestViolationWebhookQueueFull(t *testing.T) {
+	p := newTestPlugin(t, false, "http,https", "", "")
+	p.configuration.ViolationWebhookURL = "http://localhost"
+	p.API = &mockAPI{}
+
+	// The notifier isn't running, so the events stay in the queue.
+	p.violationNotifier.Store(newViolationNotifier())
+	for i := 0; i < violationQueueSize+10; i++ {
+		p.reportViolation(&model.Post{UserId: "user1", ChannelId: "channel1"}, false, nil, ruleRateLimit, actionReject, "")
+	}
+	assert.Len(t, p.violationNotifier.Load().queue, violationQueueSize)
+
+	p.configuration.ViolationWebhookURL = ""
+	p.violationNotifier.Store(newViolationNotifier())
+	p.reportViolation(&model.Post{UserId: "user1", ChannelId: "channel1"}, false, nil, ruleRateLimit, actionReject, "")
+	assert.Empty(t, p.violationNotifier.Load().queue, "no event should be queued without a webhook")
+}